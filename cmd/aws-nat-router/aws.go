@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// initAwsConfig returns the config of the base session, credentials are taken from the first provider which succeeds
+func initAwsConfig(conf *config) *aws.Config {
+	awsConfig := aws.NewConfig()
+	awsConfig.WithCredentials(credentials.NewChainCredentials(credentialProviders(conf)))
+	awsConfig.WithRegion(conf.region)
+	return awsConfig
+}
+
+// credentialProviders returns the credential providers in order: static keys, web identity token, environment,
+// shared credentials profile and finally the EC2 instance role
+func credentialProviders(conf *config) []credentials.Provider {
+	providers := []credentials.Provider{
+		&credentials.StaticProvider{
+			Value: credentials.Value{
+				AccessKeyID:     conf.awsAccessKey,
+				SecretAccessKey: conf.awsSecretKey,
+			},
+		},
+	}
+	if conf.webIdentityTokenFile != "" {
+		stsSvc := sts.New(session.New(aws.NewConfig().WithRegion(conf.region)), endpointConfig(conf.endpoints.sts)...)
+		providers = append(providers, stscreds.NewWebIdentityRoleProvider(
+			stsSvc,
+			conf.webIdentityRoleARN,
+			conf.roleSessionName,
+			conf.webIdentityTokenFile,
+		))
+	}
+	providers = append(providers,
+		&credentials.EnvProvider{},
+		&credentials.SharedCredentialsProvider{
+			Profile: conf.awsProfile,
+		},
+		&ec2rolecreds.EC2RoleProvider{
+			Client: ec2metadata.New(session.New(), endpointConfig(conf.endpoints.metadata)...),
+		},
+	)
+	return providers
+}
+
+// newTargetSession returns the session to manage target t with, assuming the role of t if set
+func newTargetSession(base *session.Session, t *targetConfig) *session.Session {
+	cfg := aws.NewConfig().WithRegion(t.region)
+	if t.roleARN != "" {
+		stsSvc := sts.New(base, endpointConfig(t.endpoints.sts)...)
+		creds := stscreds.NewCredentialsWithClient(stsSvc, t.roleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = t.roleSessionName
+			if t.externalId != "" {
+				p.ExternalID = aws.String(t.externalId)
+			}
+		})
+		cfg.WithCredentials(creds)
+	}
+	return base.Copy(cfg)
+}
+
+// endpointConfig returns the config overriding the endpoint of a service client, none if endpoint is blank
+func endpointConfig(endpoint string) []*aws.Config {
+	if endpoint == "" {
+		return nil
+	}
+	return []*aws.Config{aws.NewConfig().WithEndpoint(endpoint)}
+}
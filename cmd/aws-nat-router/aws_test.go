@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/so0k/aws-nat-router/pkg/events"
+	"github.com/so0k/aws-nat-router/pkg/status"
+)
+
+// setCredentialsEnv sets the access keys in the environment and the shared credentials file,
+// blank keys are unset. It returns a func restoring the environment.
+func setCredentialsEnv(accessKey, credentialsFile string) func() {
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID":           accessKey,
+		"AWS_ACCESS_KEY":              "",
+		"AWS_SECRET_ACCESS_KEY":       accessKey,
+		"AWS_SECRET_KEY":              "",
+		"AWS_SHARED_CREDENTIALS_FILE": credentialsFile,
+	}
+	saved := make(map[string]*string)
+	for key, value := range env {
+		if old, ok := os.LookupEnv(key); ok {
+			saved[key] = &old
+		} else {
+			saved[key] = nil
+		}
+		if value == "" {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, value)
+		}
+	}
+	return func() {
+		for key, value := range saved {
+			if value == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *value)
+			}
+		}
+	}
+}
+
+// endpointServers serves an endpoint per service, recording which services were called
+type endpointServers struct {
+	mu      sync.Mutex
+	called  map[string]bool
+	servers []*httptest.Server
+}
+
+// serve returns the URL of the endpoint of service, every request fails
+func (s *endpointServers) serve(service string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.called[service] = true
+		s.mu.Unlock()
+		http.Error(w, "endpoint for tests", http.StatusBadRequest)
+	}))
+	s.servers = append(s.servers, srv)
+	return srv.URL
+}
+
+func (s *endpointServers) close() {
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+}
+
+func TestEndpointOverrides(t *testing.T) {
+	s := &endpointServers{called: make(map[string]bool)}
+	defer s.close()
+	e := &endpoints{
+		ec2:         s.serve("ec2"),
+		autoscaling: s.serve("autoscaling"),
+		sts:         s.serve("sts"),
+		sqs:         s.serve("sqs"),
+		dynamodb:    s.serve("dynamodb"),
+		metadata:    s.serve("metadata"),
+	}
+	// the instance role is the last provider, the others should find no credentials
+	defer setCredentialsEnv("", "testdata/missing")()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := initAwsConfig(&config{region: "ap-southeast-1", endpoints: *e}).Credentials.Get(); err == nil {
+		t.Error("credentials found outside the instance metadata service")
+	}
+
+	base := session.New(aws.NewConfig().
+		WithRegion("ap-southeast-1").
+		WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", "")).
+		WithMaxRetries(0))
+	target := newTestTarget()
+	target.endpoints = e
+	target.discovery = discoveryAsg
+	target.asgNames = []string{"nat"}
+	target.election = electionDynamoDB
+	target.dynamodbTable = "leases"
+	rc, err := NewRouteController(target, "i-a", base, status.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.tagDrain(ctx, true)
+	f, err := rc.newFinder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.FindNatInstances(ctx, "squid", "vpc-1")
+	rc.elector.IsLeader(ctx, nil)
+
+	consumer, err := events.NewSQSConsumerFromSession(base, "https://sqs.ap-southeast-1.amazonaws.com/111111111111/nat", endpointConfig(e.sqs)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer.ReceiveOnce(ctx)
+
+	target.roleARN = "arn:aws:iam::222222222222:role/nat"
+	target.roleSessionName = "aws-nat-router"
+	ec2.New(newTargetSession(base, target)).DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+
+	for _, service := range []string{"ec2", "autoscaling", "sts", "sqs", "dynamodb", "metadata"} {
+		if !s.called[service] {
+			t.Errorf("%v endpoint override not used", service)
+		}
+	}
+}
+
+func TestCredentialProviders(t *testing.T) {
+	conf := &config{
+		region:               "ap-southeast-1",
+		awsProfile:           "nat",
+		webIdentityTokenFile: "testdata/token",
+		webIdentityRoleARN:   "arn:aws:iam::111111111111:role/nat",
+		roleSessionName:      "aws-nat-router",
+	}
+	var got []string
+	for _, p := range credentialProviders(conf) {
+		got = append(got, fmt.Sprintf("%T", p))
+		if p, ok := p.(*credentials.SharedCredentialsProvider); ok && p.Profile != "nat" {
+			t.Errorf("shared credentials profile %q, want nat", p.Profile)
+		}
+	}
+	want := []string{
+		"*credentials.StaticProvider",
+		"*stscreds.WebIdentityRoleProvider",
+		"*credentials.EnvProvider",
+		"*credentials.SharedCredentialsProvider",
+		"*ec2rolecreds.EC2RoleProvider",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("providers %v, want %v", got, want)
+	}
+	conf.webIdentityTokenFile = ""
+	if n := len(credentialProviders(conf)); n != len(want)-1 {
+		t.Errorf("%v providers without a web identity token, want %v", n, len(want)-1)
+	}
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+	profile := "[nat]\naws_access_key_id = PROFILE\naws_secret_access_key = PROFILE\n"
+	if err := ioutil.WriteFile(path, []byte(profile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the first provider finding credentials is used
+	for _, c := range []struct {
+		static string
+		env    string
+		want   string
+	}{
+		{static: "STATIC", env: "ENV", want: "STATIC"},
+		{env: "ENV", want: "ENV"},
+		{want: "PROFILE"},
+	} {
+		restore := setCredentialsEnv(c.env, path)
+		conf.awsAccessKey, conf.awsSecretKey = c.static, c.static
+		v, err := initAwsConfig(conf).Credentials.Get()
+		restore()
+		if err != nil {
+			t.Errorf("%+v: %v", c, err)
+		} else if v.AccessKeyID != c.want {
+			t.Errorf("%+v: access key %q, want %q", c, v.AccessKeyID, c.want)
+		}
+	}
+}
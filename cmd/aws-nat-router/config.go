@@ -0,0 +1,635 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+	"github.com/urfave/cli"
+)
+
+// modes to discover NAT Instances with
+const (
+	discoveryTags = "tags"
+	discoveryAsg  = "asg"
+	// discoveryFile reads an inventory file instead of using AWS, for labs and offline testing
+	discoveryFile = "file"
+)
+
+// modes to elect the node which updates routes with
+const (
+	// electionNone makes every node update routes, for a single router per target
+	electionNone = "none"
+	// electionOldest makes the node on the oldest live NAT Instance update routes
+	electionOldest = "oldest"
+	// electionDynamoDB makes the node holding a lease in a DynamoDB table update routes
+	electionDynamoDB = "dynamodb"
+	// electionTags makes the node holding a lease in the tags of an EC2 resource update routes
+	electionTags = "tags"
+)
+
+// config holds the settings shared by all targets
+type config struct {
+	awsAccessKey string
+	awsSecretKey string
+	awsProfile   string
+	// webIdentityTokenFile is exchanged for credentials of webIdentityRoleARN
+	webIdentityTokenFile string
+	webIdentityRoleARN   string
+	roleSessionName      string
+	region               string
+	// instanceId overrides the instance identity from instance metadata, e.g. to test elections locally
+	instanceId string
+	// central runs a controller outside the NAT Instances, replicas elect a leader by controllerId
+	central      bool
+	controllerId string
+	// shutdownTimeout limits draining on shutdown
+	shutdownTimeout time.Duration
+	// imdsV1Fallback allows instance metadata requests without an IMDSv2 token
+	imdsV1Fallback bool
+	statusAddr     string
+	sqsQueueURL    string
+	endpoints      endpoints
+	targets        []*targetConfig
+}
+
+// endpoints override the AWS endpoint of a service when set, e.g. to run against LocalStack, moto or a fake IMDS
+type endpoints struct {
+	ec2         string
+	autoscaling string
+	sts         string
+	sqs         string
+	dynamodb    string
+	metadata    string
+}
+
+// targetConfig holds the settings of a single VPC and cluster ID to reconcile
+type targetConfig struct {
+	name      string
+	vpcId     string
+	clusterId string
+	region    string
+	tags      discover.Tags
+	// discovery is one of discoveryTags, discoveryAsg or discoveryFile
+	discovery string
+	asgNames  []string
+	asgTag    string
+	inventory string
+	// endpoints are shared by all targets
+	endpoints *endpoints
+	// roleARN is assumed to manage the target, e.g. in another account
+	roleARN         string
+	externalId      string
+	roleSessionName string
+	// election is one of electionNone, electionOldest, electionDynamoDB or electionTags
+	election      string
+	dynamodbTable string
+	// leaderOrder ranks live NAT Instances for oldest election, one of election.Orders
+	leaderOrder    string
+	preferOnDemand bool
+	// stickyLeader keeps the current leader of oldest election while it is live
+	stickyLeader bool
+	// leaseResource holds the lease of the tags election, blank for the first managed Routing Table
+	leaseResource string
+	// leaseTTL is how long a lease is held after each renewal
+	leaseTTL time.Duration
+	// watchdogIntervals is how many reconciliations a passive node waits for the heartbeat of the leader, 0 to disable
+	watchdogIntervals int
+	// statusAddr is shared by all targets, peers serve their status on the same port
+	statusAddr string
+	public     bool
+	port       int
+	timeout    time.Duration
+	interval   time.Duration
+	// safetyInterval replaces interval when events are consumed
+	safetyInterval time.Duration
+	// degradedInterval replaces the interval while routes are degraded, 0 to keep the interval
+	degradedInterval time.Duration
+	// degradedIntervalSet is true when degradedInterval was set explicitly, the default is capped at the interval
+	degradedIntervalSet bool
+	// jitter randomizes intervals by up to this fraction
+	jitter float64
+	// minMutationInterval is the least time between two plans updating routes
+	minMutationInterval time.Duration
+	// probeInterval is how often the NAT Instances are health checked between reconciliations, 0 to disable
+	probeInterval time.Duration
+	// probeIntervalSet is true when probeInterval was set explicitly, the default is raised to the timeout
+	probeIntervalSet bool
+	// cycleTimeout limits a single reconciliation, AWS calls still in flight are cancelled
+	cycleTimeout time.Duration
+	events       bool
+	// central is shared by all targets, nodes of a central controller are not NAT Instances
+	central bool
+	// eligibleStates holds the instance states eligible for routes
+	eligibleStates map[string]bool
+}
+
+func parseConfig(c *cli.Context) (*config, error) {
+	conf := &config{
+		awsSecretKey:         c.String("aws-secret-key"),
+		awsAccessKey:         c.String("aws-access-key"),
+		awsProfile:           c.String("aws-profile"),
+		webIdentityTokenFile: c.String("aws-web-identity-token-file"),
+		webIdentityRoleARN:   c.String("aws-web-identity-role-arn"),
+		roleSessionName:      c.String("aws-role-session-name"),
+		region:               c.String("region"),
+		statusAddr:           c.String("status-addr"),
+		instanceId:           c.String("instance-id"),
+		central:              c.Bool("central"),
+		controllerId:         c.String("controller-id"),
+		shutdownTimeout:      c.Duration("shutdown-timeout"),
+		sqsQueueURL:          c.String("sqs-queue-url"),
+		imdsV1Fallback:       c.Bool("imdsv1-fallback"),
+		endpoints: endpoints{
+			ec2:         c.String("ec2-endpoint"),
+			autoscaling: c.String("autoscaling-endpoint"),
+			sts:         c.String("sts-endpoint"),
+			sqs:         c.String("sqs-endpoint"),
+			dynamodb:    c.String("dynamodb-endpoint"),
+			metadata:    c.String("metadata-endpoint"),
+		},
+	}
+	lStr := c.String("log-level")
+	l, err := log.ParseLevel(lStr)
+	if err != nil {
+		return nil, err
+	}
+	log.SetLevel(l)
+
+	// flags provide the settings of a single target, or the defaults for targets in the config file
+	defaults := &targetConfig{
+		vpcId:     c.String("vpc-id"),
+		clusterId: c.String("cluster-id"),
+		region:    conf.region,
+		endpoints: &conf.endpoints,
+		tags: discover.Tags{
+			ClusterId:    c.String("tag-cluster-id"),
+			Zone:         c.String("tag-zone"),
+			LeaseHolder:  c.String("tag-lease-holder"),
+			LeaseTerm:    c.String("tag-lease-term"),
+			LeaseExpires: c.String("tag-lease-expires"),
+			Heartbeat:    c.String("tag-heartbeat"),
+			Fence:        c.String("tag-fence"),
+			Priority:     c.String("tag-priority"),
+			Drain:        c.String("tag-drain"),
+		},
+		discovery:           c.String("discovery"),
+		asgNames:            splitList(c.String("asg-names")),
+		asgTag:              c.String("asg-tag"),
+		inventory:           c.String("inventory"),
+		roleARN:             c.String("aws-role-arn"),
+		externalId:          c.String("aws-external-id"),
+		roleSessionName:     conf.roleSessionName,
+		election:            c.String("election"),
+		dynamodbTable:       c.String("dynamodb-table"),
+		leaseResource:       c.String("lease-resource"),
+		leaderOrder:         c.String("leader-order"),
+		preferOnDemand:      c.BoolT("prefer-on-demand"),
+		stickyLeader:        c.Bool("sticky-leader"),
+		leaseTTL:            c.Duration("lease-ttl"),
+		watchdogIntervals:   c.Int("watchdog-intervals"),
+		statusAddr:          conf.statusAddr,
+		interval:            c.Duration("interval"),
+		public:              c.Bool("public"),
+		port:                c.Int("port"),
+		timeout:             c.Duration("timeout"),
+		safetyInterval:      c.Duration("safety-interval"),
+		cycleTimeout:        c.Duration("cycle-timeout"),
+		probeInterval:       c.Duration("probe-interval"),
+		probeIntervalSet:    c.IsSet("probe-interval"),
+		degradedInterval:    c.Duration("degraded-interval"),
+		degradedIntervalSet: c.IsSet("degraded-interval"),
+		jitter:              c.Float64("jitter"),
+		minMutationInterval: c.Duration("min-mutation-interval"),
+		events:              conf.sqsQueueURL != "",
+		central:             conf.central,
+	}
+	// --ec2-election is kept as an alias of --election oldest
+	if c.Bool("ec2-election") {
+		if defaults.election != electionNone && defaults.election != electionOldest {
+			return nil, errors.Errorf("--ec2-election conflicts with --election %v", defaults.election)
+		}
+		defaults.election = electionOldest
+	}
+	defaults.eligibleStates, err = parseStates(strings.Split(c.String("eligible-states"), ","))
+	if err != nil {
+		return nil, err
+	}
+
+	if path := c.String("config"); path != "" {
+		conf.targets, err = loadTargets(path, defaults)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		defaults.name = fmt.Sprintf("%v/%v", defaults.vpcId, defaults.clusterId)
+		conf.targets = []*targetConfig{defaults}
+	}
+
+	for _, t := range conf.targets {
+		t.adjustDefaults()
+		if err := t.validate(conf); err != nil {
+			return nil, errors.Wrapf(err, "target %q", t.name)
+		}
+	}
+
+	if conf.webIdentityTokenFile != "" && conf.webIdentityRoleARN == "" {
+		return nil, errors.New("aws-web-identity-role-arn can not be blank when using a web identity token")
+	}
+
+	if err := conf.endpoints.validate(); err != nil {
+		return nil, err
+	}
+
+	if conf.central {
+		if conf.instanceId != "" {
+			return nil, errors.New("instance-id does not apply to a central controller, use controller-id")
+		}
+		if conf.controllerId == "" {
+			conf.controllerId, err = os.Hostname()
+			if err != nil {
+				return nil, errors.Wrap(err, "Unable to default controller-id to the hostname")
+			}
+		}
+	}
+
+	if conf.shutdownTimeout <= 0 {
+		return nil, errors.New("shutdown-timeout should be positive")
+	}
+
+	if conf.statusAddr != "" {
+		if _, _, err := net.SplitHostPort(conf.statusAddr); err != nil {
+			return nil, errors.Wrap(err, "status-addr should be HOST:PORT, e.g. :8080")
+		}
+	}
+
+	//TODO: validate region?
+
+	return conf, nil
+}
+
+func (t *targetConfig) validate(conf *config) error {
+	// validate vpc-id
+	if len(t.vpcId) == 0 {
+		return errors.New("vpc-id can not be blank")
+	}
+
+	if err := t.tags.Validate(); err != nil {
+		return err
+	}
+
+	switch t.discovery {
+	case discoveryTags:
+	case discoveryAsg:
+		if len(t.asgNames) == 0 && t.asgTag == "" {
+			return errors.New("asg-names or asg-tag required for asg discovery")
+		}
+	case discoveryFile:
+		if t.inventory == "" {
+			return errors.New("inventory required for file discovery")
+		}
+	default:
+		return errors.Errorf("Unknown discovery %q, expected %v, %v or %v", t.discovery, discoveryTags, discoveryAsg, discoveryFile)
+	}
+
+	if t.interval < time.Second {
+		return errors.New("Interval should not be less than 1 second")
+	}
+
+	if t.events && t.safetyInterval < t.interval {
+		return errors.New("Safety interval should not be less than interval")
+	}
+	interval := t.reconcileInterval()
+
+	if t.cycleTimeout <= 0 {
+		return errors.New("cycle-timeout should be positive")
+	}
+
+	if t.degradedInterval != 0 && (t.degradedInterval < time.Second || t.degradedInterval > interval) {
+		return errors.Errorf("degraded-interval should be 0 to disable it or between 1 second and the interval (%v)", interval)
+	}
+	if t.jitter < 0 || t.jitter > 0.5 {
+		return errors.New("jitter should be between 0 and 0.5")
+	}
+	if t.minMutationInterval < 0 {
+		return errors.New("min-mutation-interval can not be negative")
+	}
+
+	// a probe should finish before the next one starts
+	if t.probeInterval != 0 && t.probeInterval < t.timeout {
+		return errors.Errorf("probe-interval should be 0 to disable probing or at least the health check timeout (%v)", t.timeout)
+	}
+
+	// replicas of a central controller would all update routes without a lease
+	if t.central && t.election != electionDynamoDB && t.election != electionTags {
+		return errors.Errorf("a central controller requires dynamodb or tags election, not %q", t.election)
+	}
+	switch t.election {
+	case electionNone, electionOldest:
+	case electionDynamoDB:
+		if t.dynamodbTable == "" {
+			return errors.New("dynamodb-table required for dynamodb election")
+		}
+	case electionTags:
+		if t.discovery == discoveryFile {
+			return errors.New("tags election requires AWS, it can not be used with file discovery")
+		}
+	default:
+		return errors.Errorf("Unknown election %q, expected %v, %v, %v or %v", t.election, electionNone, electionOldest, electionDynamoDB, electionTags)
+	}
+	// leases are renewed every third of the lease ttl, independently of reconciliations
+	if (t.election == electionDynamoDB || t.election == electionTags) && t.leaseTTL < 3*time.Second {
+		return errors.New("lease-ttl should be at least 3 seconds")
+	}
+	switch t.leaderOrder {
+	case election.OrderOldest, election.OrderLowestId, election.OrderPriority:
+	default:
+		return errors.Errorf("Unknown leader-order %q, expected one of %v", t.leaderOrder, strings.Join(election.Orders, ", "))
+	}
+	// leases keep their holder while it renews
+	if t.stickyLeader && t.election != electionOldest {
+		return errors.New("sticky-leader only applies to oldest election, leases stay with their holder")
+	}
+	// a single late reconciliation should not cause a takeover
+	if t.watchdogIntervals != 0 && t.watchdogIntervals < 2 {
+		return errors.New("watchdog-intervals should be 0 to disable the watchdog or at least 2")
+	}
+	return nil
+}
+
+// adjustDefaults fits the settings left at their defaults to the intervals and timeout of the target
+func (t *targetConfig) adjustDefaults() {
+	if !t.degradedIntervalSet && t.degradedInterval > t.reconcileInterval() {
+		t.degradedInterval = t.reconcileInterval()
+	}
+	if !t.probeIntervalSet && t.probeInterval != 0 && t.probeInterval < t.timeout {
+		t.probeInterval = t.timeout
+	}
+}
+
+// reconcileInterval returns the longest interval between reconciliations
+func (t *targetConfig) reconcileInterval() time.Duration {
+	if t.events {
+		return t.safetyInterval
+	}
+	return t.interval
+}
+
+// validate checks every endpoint which is set is an absolute URL
+func (e endpoints) validate() error {
+	for name, endpoint := range map[string]string{
+		"ec2-endpoint":         e.ec2,
+		"autoscaling-endpoint": e.autoscaling,
+		"sts-endpoint":         e.sts,
+		"sqs-endpoint":         e.sqs,
+		"dynamodb-endpoint":    e.dynamodb,
+		"metadata-endpoint":    e.metadata,
+	} {
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Errorf("%v %q should be an absolute URL such as http://localhost:4566", name, endpoint)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping blank items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseStates parses a list of instance states
+func parseStates(ss []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, state := range discover.InstanceStates {
+		known[state] = true
+	}
+	states := make(map[string]bool)
+	for _, state := range ss {
+		state = strings.TrimSpace(state)
+		if state == "" {
+			continue
+		}
+		if !known[state] {
+			return nil, errors.Errorf("Unknown instance state %q, expected one of %v", state, discover.InstanceStates)
+		}
+		states[state] = true
+	}
+	if len(states) == 0 {
+		return nil, errors.New("eligible-states can not be blank")
+	}
+	return states, nil
+}
+
+// fileConfig is the format of the --config file
+type fileConfig struct {
+	// Tags overrides the tag keys of every target, unset keys keep the flag settings
+	Tags    *discover.Tags `json:"tags"`
+	Targets []fileTarget   `json:"targets"`
+}
+
+// fileTarget overrides the flag settings for a single target, unset fields keep the flag settings
+type fileTarget struct {
+	Name            string   `json:"name"`
+	VpcId           string   `json:"vpcId"`
+	ClusterId       string   `json:"clusterId"`
+	Region          string   `json:"region"`
+	Discovery       string   `json:"discovery"`
+	AsgNames        []string `json:"asgNames"`
+	AsgTag          string   `json:"asgTag"`
+	Inventory       string   `json:"inventory"`
+	RoleARN         string   `json:"roleArn"`
+	ExternalId      string   `json:"externalId"`
+	RoleSessionName string   `json:"roleSessionName"`
+	// EC2Election is kept as an alias of "election": "oldest"
+	EC2Election         *bool     `json:"ec2Election"`
+	Election            string    `json:"election"`
+	DynamoDBTable       string    `json:"dynamodbTable"`
+	LeaseResource       string    `json:"leaseResource"`
+	LeaderOrder         string    `json:"leaderOrder"`
+	PreferOnDemand      *bool     `json:"preferOnDemand"`
+	StickyLeader        *bool     `json:"stickyLeader"`
+	LeaseTTL            duration  `json:"leaseTtl"`
+	WatchdogIntervals   *int      `json:"watchdogIntervals"`
+	Public              *bool     `json:"public"`
+	Port                int       `json:"port"`
+	Timeout             duration  `json:"timeout"`
+	Interval            duration  `json:"interval"`
+	SafetyInterval      duration  `json:"safetyInterval"`
+	CycleTimeout        duration  `json:"cycleTimeout"`
+	ProbeInterval       *duration `json:"probeInterval"`
+	DegradedInterval    *duration `json:"degradedInterval"`
+	Jitter              *float64  `json:"jitter"`
+	MinMutationInterval *duration `json:"minMutationInterval"`
+	EligibleStates      []string  `json:"eligibleStates"`
+}
+
+// duration unmarshals a JSON string such as "10s" into a time.Duration
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "duration should be a string such as \"10s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// loadTargets reads the targets from the config file at path
+func loadTargets(path string, defaults *targetConfig) ([]*targetConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read config file")
+	}
+	tags := defaults.tags
+	fc := &fileConfig{
+		Tags: &tags,
+	}
+	if err := json.Unmarshal(b, fc); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse config file")
+	}
+	if len(fc.Targets) == 0 {
+		return nil, errors.Errorf("config file %v has no targets", path)
+	}
+
+	var targets []*targetConfig
+	names := make(map[string]bool)
+	for _, ft := range fc.Targets {
+		t := *defaults
+		t.tags = tags
+		if ft.VpcId != "" {
+			t.vpcId = ft.VpcId
+		}
+		if ft.ClusterId != "" {
+			t.clusterId = ft.ClusterId
+		}
+		if ft.Region != "" {
+			t.region = ft.Region
+		}
+		if ft.Discovery != "" {
+			t.discovery = ft.Discovery
+		}
+		if len(ft.AsgNames) > 0 {
+			t.asgNames = ft.AsgNames
+		}
+		if ft.AsgTag != "" {
+			t.asgTag = ft.AsgTag
+		}
+		if ft.Inventory != "" {
+			t.inventory = ft.Inventory
+		}
+		if ft.RoleARN != "" {
+			t.roleARN = ft.RoleARN
+			// an external id belongs to a role, do not inherit it
+			t.externalId = ft.ExternalId
+		} else if ft.ExternalId != "" {
+			t.externalId = ft.ExternalId
+		}
+		if ft.RoleSessionName != "" {
+			t.roleSessionName = ft.RoleSessionName
+		}
+		if ft.EC2Election != nil {
+			t.election = electionNone
+			if *ft.EC2Election {
+				t.election = electionOldest
+			}
+		}
+		if ft.Election != "" {
+			t.election = ft.Election
+		}
+		if ft.DynamoDBTable != "" {
+			t.dynamodbTable = ft.DynamoDBTable
+		}
+		if ft.LeaseResource != "" {
+			t.leaseResource = ft.LeaseResource
+		}
+		if ft.LeaderOrder != "" {
+			t.leaderOrder = ft.LeaderOrder
+		}
+		if ft.PreferOnDemand != nil {
+			t.preferOnDemand = *ft.PreferOnDemand
+		}
+		if ft.StickyLeader != nil {
+			t.stickyLeader = *ft.StickyLeader
+		}
+		if ft.LeaseTTL.Duration != 0 {
+			t.leaseTTL = ft.LeaseTTL.Duration
+		}
+		if ft.WatchdogIntervals != nil {
+			t.watchdogIntervals = *ft.WatchdogIntervals
+		}
+		if ft.Public != nil {
+			t.public = *ft.Public
+		}
+		if ft.Port != 0 {
+			t.port = ft.Port
+		}
+		if ft.Timeout.Duration != 0 {
+			t.timeout = ft.Timeout.Duration
+		}
+		if ft.Interval.Duration != 0 {
+			t.interval = ft.Interval.Duration
+		}
+		if ft.SafetyInterval.Duration != 0 {
+			t.safetyInterval = ft.SafetyInterval.Duration
+		}
+		if ft.CycleTimeout.Duration != 0 {
+			t.cycleTimeout = ft.CycleTimeout.Duration
+		}
+		if ft.ProbeInterval != nil {
+			t.probeInterval = ft.ProbeInterval.Duration
+			t.probeIntervalSet = true
+		}
+		if ft.DegradedInterval != nil {
+			t.degradedInterval = ft.DegradedInterval.Duration
+			t.degradedIntervalSet = true
+		}
+		if ft.Jitter != nil {
+			t.jitter = *ft.Jitter
+		}
+		if ft.MinMutationInterval != nil {
+			t.minMutationInterval = ft.MinMutationInterval.Duration
+		}
+		if len(ft.EligibleStates) > 0 {
+			t.eligibleStates, err = parseStates(ft.EligibleStates)
+			if err != nil {
+				return nil, err
+			}
+		}
+		t.name = ft.Name
+		if t.name == "" {
+			t.name = fmt.Sprintf("%v/%v", t.vpcId, t.clusterId)
+		}
+		if names[t.name] {
+			return nil, errors.Errorf("target %q is defined more than once", t.name)
+		}
+		names[t.name] = true
+		targets = append(targets, &t)
+	}
+	return targets, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+)
+
+// newTestTarget returns a valid target with the flag defaults
+func newTestTarget() *targetConfig {
+	return &targetConfig{
+		name:             "vpc-1/squid",
+		vpcId:            "vpc-1",
+		clusterId:        "squid",
+		region:           "ap-southeast-1",
+		tags:             discover.DefaultTags,
+		discovery:        discoveryTags,
+		election:         electionNone,
+		leaderOrder:      election.OrderOldest,
+		leaseTTL:         30 * time.Second,
+		timeout:          50 * time.Millisecond,
+		interval:         10 * time.Second,
+		safetyInterval:   time.Minute,
+		cycleTimeout:     10 * time.Second,
+		degradedInterval: 2 * time.Second,
+		probeInterval:    500 * time.Millisecond,
+	}
+}
+
+func TestAdjustDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      func(t *targetConfig)
+		degraded time.Duration
+		probe    time.Duration
+		valid    bool
+	}{
+		{
+			name:     "default degraded interval below the interval",
+			set:      func(t *targetConfig) {},
+			degraded: 2 * time.Second,
+			probe:    500 * time.Millisecond,
+			valid:    true,
+		},
+		{
+			name:     "default degraded interval capped at the interval",
+			set:      func(t *targetConfig) { t.interval = time.Second },
+			degraded: time.Second,
+			probe:    500 * time.Millisecond,
+			valid:    true,
+		},
+		{
+			name: "explicit degraded interval above the interval",
+			set: func(t *targetConfig) {
+				t.interval = time.Second
+				t.degradedIntervalSet = true
+			},
+			degraded: 2 * time.Second,
+			probe:    500 * time.Millisecond,
+		},
+		{
+			name:     "default probe interval raised to the timeout",
+			set:      func(t *targetConfig) { t.timeout = 2 * time.Second },
+			degraded: 2 * time.Second,
+			probe:    2 * time.Second,
+			valid:    true,
+		},
+		{
+			name: "explicit probe interval below the timeout",
+			set: func(t *targetConfig) {
+				t.timeout = 2 * time.Second
+				t.probeIntervalSet = true
+			},
+			degraded: 2 * time.Second,
+			probe:    500 * time.Millisecond,
+		},
+		{
+			name: "probing disabled",
+			set: func(t *targetConfig) {
+				t.timeout = 2 * time.Second
+				t.probeInterval = 0
+			},
+			degraded: 2 * time.Second,
+			valid:    true,
+		},
+	}
+	for _, tt := range tests {
+		target := newTestTarget()
+		tt.set(target)
+		target.adjustDefaults()
+		if target.degradedInterval != tt.degraded {
+			t.Errorf("%v: degraded interval %v, want %v", tt.name, target.degradedInterval, tt.degraded)
+		}
+		if target.probeInterval != tt.probe {
+			t.Errorf("%v: probe interval %v, want %v", tt.name, target.probeInterval, tt.probe)
+		}
+		if err := target.validate(&config{}); (err == nil) != tt.valid {
+			t.Errorf("%v: validate returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestValidateCentralElection(t *testing.T) {
+	for e, valid := range map[string]bool{
+		electionNone:     false,
+		electionOldest:   false,
+		electionDynamoDB: true,
+		electionTags:     true,
+	} {
+		target := newTestTarget()
+		target.central = true
+		target.election = e
+		target.dynamodbTable = "leases"
+		if err := target.validate(&config{}); (err == nil) != valid {
+			t.Errorf("central controller with %q election: validate returned %v", e, err)
+		}
+	}
+}
+
+func TestValidateLeaseElectionWithEvents(t *testing.T) {
+	for _, e := range []string{electionDynamoDB, electionTags} {
+		// reconciliations are a safety interval apart, longer than the lease ttl
+		target := newTestTarget()
+		target.events = true
+		target.election = e
+		target.dynamodbTable = "leases"
+		target.adjustDefaults()
+		if err := target.validate(&config{}); err != nil {
+			t.Errorf("%v election with events: %v", e, err)
+		}
+	}
+}
+
+// writeConfig writes the config file content to a temporary file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "targets.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTargets(t *testing.T) {
+	defaults := newTestTarget()
+	defaults.election = electionOldest
+	defaults.roleARN = "arn:aws:iam::111111111111:role/nat"
+	defaults.externalId = "secret"
+	path := writeConfig(t, `{"targets": [
+		{"vpcId": "vpc-2"},
+		{"name": "no-election", "ec2Election": false, "interval": "20s", "probeInterval": "0s"},
+		{"name": "tags", "ec2Election": true, "election": "tags"},
+		{"name": "other-account", "roleArn": "arn:aws:iam::222222222222:role/nat"}
+	]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	targets, err := loadTargets(path, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 4 {
+		t.Fatalf("loaded %v targets, want 4", len(targets))
+	}
+	// unset fields keep the flag settings
+	if got := targets[0]; got.name != "vpc-2/squid" || got.vpcId != "vpc-2" || got.clusterId != "squid" ||
+		got.election != electionOldest || got.interval != defaults.interval || got.tags != defaults.tags ||
+		got.externalId != defaults.externalId {
+		t.Errorf("target %+v does not inherit the flag settings", got)
+	}
+	if got := targets[1]; got.election != electionNone || got.interval != 20*time.Second ||
+		got.probeInterval != 0 || !got.probeIntervalSet || got.vpcId != defaults.vpcId {
+		t.Errorf("target %+v, want no election every 20s without probes", got)
+	}
+	// election takes precedence over its ec2Election alias
+	if got := targets[2]; got.election != electionTags {
+		t.Errorf("target %q has %v election, want %v", got.name, got.election, electionTags)
+	}
+	// an external id belongs to the role it was set for
+	if got := targets[3]; got.externalId != "" {
+		t.Errorf("target %q inherited external id %q of another role", got.name, got.externalId)
+	}
+	if defaults.election != electionOldest || defaults.vpcId != "vpc-1" {
+		t.Errorf("defaults modified to %+v", defaults)
+	}
+}
+
+func TestLoadTargetsDuplicateNames(t *testing.T) {
+	for _, content := range []string{
+		`{"targets": [{"name": "a", "vpcId": "vpc-2"}, {"name": "a", "vpcId": "vpc-3"}]}`,
+		// names default to vpc/cluster
+		`{"targets": [{"vpcId": "vpc-2"}, {"vpcId": "vpc-2", "interval": "20s"}]}`,
+	} {
+		path := writeConfig(t, content)
+		defer os.RemoveAll(filepath.Dir(path))
+		if _, err := loadTargets(path, newTestTarget()); err == nil {
+			t.Errorf("loaded %v without error", content)
+		}
+	}
+}
+
+func TestLoadTargetsTags(t *testing.T) {
+	path := writeConfig(t, `{"tags": {"clusterId": "nat/id", "drain": "nat/drain"}, "targets": [{"vpcId": "vpc-2"}]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+	targets, err := loadTargets(path, newTestTarget())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unset keys keep the flag settings
+	want := discover.DefaultTags
+	want.ClusterId, want.Drain = "nat/id", "nat/drain"
+	if targets[0].tags != want {
+		t.Errorf("tags %+v, want %+v", targets[0].tags, want)
+	}
+
+	for _, tags := range []string{
+		`{"fence": ""}`,
+		`{"drain": "aws-nat-router/id"}`,
+	} {
+		path := writeConfig(t, `{"tags": `+tags+`, "targets": [{"vpcId": "vpc-2"}]}`)
+		defer os.RemoveAll(filepath.Dir(path))
+		targets, err := loadTargets(path, newTestTarget())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := targets[0].validate(&config{}); err == nil {
+			t.Errorf("tags %v accepted", tags)
+		}
+	}
+}
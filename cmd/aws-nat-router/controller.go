@@ -0,0 +1,663 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+	"github.com/so0k/aws-nat-router/pkg/events"
+	"github.com/so0k/aws-nat-router/pkg/healthcheck"
+	"github.com/so0k/aws-nat-router/pkg/router"
+	"github.com/so0k/aws-nat-router/pkg/status"
+)
+
+const (
+	roleActive  = "ACTIVE"
+	rolePassive = "PASSIVE"
+)
+
+// peerStatusTimeout limits how long the watchdog waits for the status of the leader
+const peerStatusTimeout = time.Second
+
+// drainPollInterval is how often a node shutting down checks if its routes were moved
+const drainPollInterval = 2 * time.Second
+
+// RouteController reconciles the routes of a single target, each target has its own RouteController
+type RouteController struct {
+	config *targetConfig
+	// nodeId identifies this node in elections, the NAT Instance it runs on or the id of a central controller
+	nodeId  string
+	session *session.Session
+	ec2     ec2iface.EC2API
+	status  *status.Status
+	// healthCheck checks a NAT Instance at addr, it is replaced in tests
+	healthCheck func(addr string, timeout time.Duration) error
+	elector     election.Elector
+	// leader is the NAT Instance which led the last cycle in oldest election, kept for sticky leaders
+	leader string
+	// draining is set on shutdown, the NAT Instance of this node is drained from then on
+	draining bool
+	// ctx is cancelled by Shutdown, which ends Run and abandons the reconciliation in progress,
+	// Run closes stopped once it returns
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	// triggers is nil unless events are consumed
+	triggers chan struct{}
+	// schedule spaces reconciliations, lastMutation is when this node last started updating routes
+	schedule     *schedule
+	lastMutation time.Time
+	// transitions receives when a probe finds a NAT Instance changed health
+	transitions chan struct{}
+	// mu guards probed, the NAT Instances health checked by the last reconciliation as updated by probes,
+	// and the ids of the NAT Instances and Routing Tables last discovered, nil until discovered
+	mu            sync.Mutex
+	probed        []probeTarget
+	natInstances  map[string]bool
+	routingTables map[string]bool
+	// finder is kept across cycles, so the zones and the inventory are only read again when they may have changed
+	finder discover.Finder
+	log    *log.Entry
+}
+
+// NewRouteController returns a RouteController for target t run by node nodeId
+func NewRouteController(t *targetConfig, nodeId string, session *session.Session, status *status.Status) (*RouteController, error) {
+	c := &RouteController{
+		config:      t,
+		nodeId:      nodeId,
+		session:     session,
+		ec2:         ec2.New(session, endpointConfig(t.endpoints.ec2)...),
+		status:      status,
+		healthCheck: healthcheck.TCPCheck,
+		stopped:     make(chan struct{}),
+		transitions: make(chan struct{}, 1),
+		schedule:    newSchedule(t),
+		log: log.WithFields(log.Fields{
+			"target":  t.name,
+			"vpc":     t.vpcId,
+			"cluster": t.clusterId,
+		}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	var err error
+	c.elector, err = c.newElector()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Trigger requests an immediate reconciliation, it does not block
+func (c *RouteController) Trigger() {
+	select {
+	case c.triggers <- struct{}{}:
+	default:
+		// a reconciliation is already pending
+	}
+}
+
+// Concerns returns true if event e may concern the target: it names a NAT Instance or Routing Table last discovered
+// or the vpc of the target. Events which do not name a resource, e.g. DisassociateRouteTable, concern every target,
+// as does an instance which was not discovered starting, it may be a replacement NAT Instance.
+func (c *RouteController) Concerns(e *events.Event) bool {
+	if e.Region != "" && c.config.region != "" && e.Region != c.config.region {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := e.Detail
+	switch {
+	case d.InstanceId != "" && c.natInstances != nil:
+		return c.natInstances[d.InstanceId] || d.State == "" ||
+			d.State == ec2.InstanceStateNamePending || d.State == ec2.InstanceStateNameRunning
+	case d.RequestParameters.RouteTableId != "" && c.routingTables != nil:
+		return c.routingTables[d.RequestParameters.RouteTableId]
+	case d.RequestParameters.VpcId != "":
+		return d.RequestParameters.VpcId == c.config.vpcId
+	}
+	return true
+}
+
+// Run reconciles until Shutdown is called
+func (c *RouteController) Run() error {
+	defer close(c.stopped)
+	// a drain requested before a restart no longer applies
+	if err := c.tagDrain(c.ctx, false); err != nil {
+		c.log.Warnf("Unable to clear drain request: %v", err)
+	}
+	if c.config.probeInterval > 0 {
+		go c.probe()
+	}
+	// leases are renewed between reconciliations, which may be further apart than the lease ttl when events are consumed
+	var renewals <-chan time.Time
+	if c.config.election == electionDynamoDB || c.config.election == electionTags {
+		ticker := time.NewTicker(c.config.leaseTTL / 3)
+		defer ticker.Stop()
+		renewals = ticker.C
+	}
+	for {
+		err := c.RunOnce(c.ctx)
+		if err != nil {
+			c.log.Warnf("Error updating routes: %v", err)
+		}
+		delay := c.schedule.next(c.status.Last(c.config.name))
+		c.log.Debugf("Next reconciliation in %v", delay)
+		timer := time.NewTimer(delay)
+	wait:
+		// without events triggers is nil and never receives, without a lease election neither does renewals
+		for {
+			select {
+			case <-c.ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+				break wait
+			case <-c.triggers:
+				c.log.Info("Event received, reconciling")
+				break wait
+			case <-c.transitions:
+				c.log.Info("Health changed, reconciling")
+				break wait
+			case <-renewals:
+				if c.renewLease() {
+					c.log.Info("Lease lost, reconciling")
+					break wait
+				}
+			}
+		}
+		timer.Stop()
+	}
+}
+
+// renewLease renews the lease held by this node between reconciliations, it returns true if the lease was lost.
+// It does nothing unless this node holds a lease, passive nodes acquire the lease when they reconcile.
+func (c *RouteController) renewLease() bool {
+	l, ok := c.elector.(election.Leaser)
+	if !ok || l.Expires().IsZero() {
+		return false
+	}
+	ctx, cancel := context.WithDeadline(c.ctx, l.Expires())
+	defer cancel()
+	// the lease elections do not rank the live NAT Instances
+	leader, err := l.IsLeader(ctx, nil)
+	if err != nil {
+		c.log.Warnf("Unable to renew lease: %v", err)
+		return false
+	}
+	return !leader
+}
+
+// RunOnce reconciles the routes once, AWS calls are cancelled with ctx or once the cycle timeout passed
+func (c *RouteController) RunOnce(ctx context.Context) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.cycleTimeout)
+	defer cancel()
+	c.log.Info("Reconciliation started")
+	cycle := &status.Cycle{
+		Started: time.Now(),
+		Role:    rolePassive,
+	}
+	var f discover.Finder
+	defer func() {
+		cycle.Finished = time.Now()
+		if err != nil {
+			cycle.Error = err.Error()
+			cycle.Throttled = cycle.Throttled || throttled(err)
+		}
+		if f != nil {
+			// report skipped or malformed resources for this cycle
+			cycle.Report = f.Report()
+			cycle.Report.Log()
+		}
+		c.status.Update(c.config.name, cycle)
+	}()
+
+	f, err = c.ensureFinder()
+	if err != nil {
+		return err
+	}
+	nis, err := f.FindNatInstances(ctx, c.config.clusterId, c.config.vpcId)
+	if err != nil {
+		return err
+	}
+	natInstances := make(map[string]bool, len(nis))
+	for _, ni := range nis {
+		natInstances[ni.Id] = true
+	}
+	c.mu.Lock()
+	c.natInstances = natInstances
+	c.mu.Unlock()
+
+	// Check liveness for each instance
+	var liveNis, deadNis []*discover.NatInstance
+	var self *discover.NatInstance
+	var probed []probeTarget
+	for _, ni := range nis {
+		if c.draining && ni.Id == c.nodeId {
+			ni.Drain = true
+			self = ni
+		}
+		if !c.config.eligibleStates[ni.State] || !ni.InService() || ni.Draining() {
+			if ni.Draining() {
+				// move routes away now rather than waiting for the health check to time out
+				c.log.Infof("Instance %q is %v %v, draining", ni.Id, ni.State, ni.LifecycleState)
+				cycle.Draining = append(cycle.Draining, ni.Id)
+			} else {
+				c.log.Debugf("Instance %q is %v %v, not eligible", ni.Id, ni.State, ni.LifecycleState)
+			}
+			deadNis = append(deadNis, ni)
+			cycle.Unhealthy = append(cycle.Unhealthy, ni.Id)
+			continue
+		}
+		ip := ni.PrivateIP
+		if c.config.public {
+			ip = ni.PublicIP
+		}
+		if ip == "" {
+			c.log.Debugf("Instance %q has no address to check, considered dead :(", ni.Id)
+			deadNis = append(deadNis, ni)
+			cycle.Unhealthy = append(cycle.Unhealthy, ni.Id)
+			continue
+		}
+		addr := fmt.Sprintf("%v:%v", ip, c.config.port)
+		err := c.healthCheck(addr, c.config.timeout)
+		probed = append(probed, probeTarget{id: ni.Id, addr: addr, healthy: err == nil})
+		if err != nil {
+			c.log.Debugf("Instance %q (%v) is dead :(", ni.Id, addr)
+			c.log.Debugf("\tError for TCPCheck: %v", err)
+			deadNis = append(deadNis, ni)
+			cycle.Unhealthy = append(cycle.Unhealthy, ni.Id)
+		} else {
+			c.log.Debugf("Instance %q (%v) is alive!", ni.Id, addr)
+			liveNis = append(liveNis, ni)
+			cycle.Healthy = append(cycle.Healthy, ni.Id)
+		}
+	}
+	c.mu.Lock()
+	c.probed = probed
+	c.mu.Unlock()
+	election.Rank(liveNis, c.config.leaderOrder, c.config.preferOnDemand)
+	// a node draining its own NAT Instance keeps its rank in the election, so it moves its routes while it leads
+	candidates := liveNis
+	if self != nil {
+		candidates = append([]*discover.NatInstance{self}, liveNis...)
+		election.Rank(candidates, c.config.leaderOrder, c.config.preferOnDemand)
+	}
+	if c.config.stickyLeader {
+		if c.leader == "" {
+			// after a restart, follow the leader the peers agree on
+			c.leader = c.peerLeader(ctx, candidates)
+		}
+		if c.leader != "" && !election.Stick(candidates, c.leader) {
+			c.log.Infof("Leader %q is no longer live", c.leader)
+		}
+	}
+	c.leader = ""
+	if c.config.election == electionOldest && len(candidates) > 0 {
+		c.leader = candidates[0].Id
+		cycle.Leader = c.leader
+	}
+
+	c.log.Infof("Healthy NAT Instances found: %v", len(liveNis))
+	leader := false
+	if len(candidates) > 0 {
+		leader, err = c.elector.IsLeader(ctx, candidates)
+		if err != nil {
+			return err
+		}
+		if w, ok := c.elector.(*election.Watchdog); ok {
+			cycle.Takeover = w.Takeover()
+		}
+	}
+	if leader {
+		c.log.Info(roleActive)
+		cycle.Role = roleActive
+		cycle.Leader = c.nodeId
+		// stop acting on leadership once the lease expires
+		if l, ok := c.elector.(election.Leaser); ok && !l.Expires().IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, l.Expires())
+			defer cancel()
+		}
+		// the term of a lease fences route updates of former leaders
+		fencer, _ := c.elector.(election.Fencer)
+		if fencer != nil {
+			cycle.Term = fencer.Term()
+		}
+		rts, err := f.FindRoutingTables(ctx, c.config.clusterId, c.config.vpcId)
+		if err != nil {
+			return err
+		}
+		routingTables := make(map[string]bool, len(rts))
+		for _, rt := range rts {
+			routingTables[rt.Id] = true
+		}
+		c.mu.Lock()
+		c.routingTables = routingTables
+		c.mu.Unlock()
+
+		live := make(map[string]bool, len(liveNis))
+		for _, ni := range liveNis {
+			live[ni.Id] = true
+		}
+		for _, rt := range rts {
+			if rt.Blackhole || !live[rt.EgressNatInstanceId] {
+				cycle.Degraded = true
+			}
+		}
+
+		// Rebuild allocation based on discovered information
+		oldNias := router.GetCurrentAllocation(liveNis, rts)
+
+		// Allocate routes to live NATInstances
+		newNias := router.AllocateRoutes(liveNis, rts)
+		cycle.Allocations = status.NewAllocations(newNias)
+
+		// Verify if allocation differs to avoid exceeding API rate limits
+		if router.AllocationDiffers(oldNias, newNias) {
+			c.log.Info("Updating Routes and Source Destination Checks ... ")
+			// Apply Allocations and ensure SourceDestCheck is disabled
+			r, err := c.newRouter()
+			if err != nil {
+				return err
+			}
+			if wait := c.config.minMutationInterval - time.Since(c.lastMutation); wait > 0 {
+				c.log.Infof("Waiting %v before updating routes again", wait.Round(time.Millisecond))
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return errors.Wrap(ctx.Err(), "Plan aborted")
+				}
+			}
+			c.lastMutation = time.Now()
+			if cycle.Term > 0 {
+				// this node may have paused since it was elected
+				if err := fencer.CheckTerm(ctx, cycle.Term); err != nil {
+					return errors.Wrap(err, "Plan aborted")
+				}
+				if c.config.discovery != discoveryFile {
+					r = router.NewFencedRouter(r, c.ec2, c.config.tags.Fence, cycle.Term)
+				}
+			}
+			// keep applying after a failure, remaining changes are retried next cycle
+			failed := 0
+			for _, nia := range newNias {
+				if ctx.Err() != nil {
+					// the lease expired or the cycle timed out
+					return errors.Wrap(ctx.Err(), "Plan aborted")
+				}
+				if err := r.PreventSourceDestCheck(ctx, nia.NatInstance); err != nil {
+					cycle.Throttled = cycle.Throttled || throttled(err)
+					c.log.Warnf("Instance %q: %v", nia.NatInstance.Id, err)
+					failed++
+				}
+				for _, rt := range nia.RoutingTables {
+					// only touch routing tables which changed egress
+					if rt.EgressNatInstanceId == nia.NatInstance.Id {
+						continue
+					}
+					// hardcoding egress = 0.0.0.0/0
+					if err := r.UpsertNatRoute(ctx, "0.0.0.0/0", nia.NatInstance, rt); err != nil {
+						if errors.Cause(err) == election.ErrStaleTerm || ctx.Err() != nil {
+							return errors.Wrap(err, "Plan aborted")
+						}
+						cycle.Throttled = cycle.Throttled || throttled(err)
+						c.log.Warnf("RoutingTable %q: %v", rt.Id, err)
+						failed++
+					}
+				}
+			}
+			if failed > 0 {
+				return errors.Errorf("%v updates failed", failed)
+			}
+		} else {
+			c.log.Info("Routes are already up to date")
+		}
+		if err := c.elector.Heartbeat(ctx); err != nil {
+			return err
+		}
+	} else {
+		c.log.Info(rolePassive)
+	}
+	// TODO(so0k): start recovery for unhealthy instances (just issue command... on next iteration Nat Instances will be re-evaluated)
+	return nil
+}
+
+// Shutdown stops Run, abandoning the reconciliation in progress, and drains the NAT Instance of this node
+// before deadline: it asks for its routes to be moved with the drain tag, moves them itself if it leads
+// and hands over leadership, then waits for the leader to move any remaining routes.
+// A central controller only hands over leadership.
+func (c *RouteController) Shutdown(deadline time.Time) error {
+	c.cancel()
+	select {
+	case <-c.stopped:
+	case <-time.After(time.Until(deadline)):
+		return errors.New("Timed out waiting for the reconciliation in progress")
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if c.config.central {
+		return c.elector.Resign(ctx)
+	}
+
+	c.log.Infof("Draining %v", c.nodeId)
+	c.draining = true
+	if err := c.tagDrain(ctx, true); err != nil {
+		// the leader finds out once the health check fails
+		c.log.Warnf("Unable to request drain: %v", err)
+	}
+	if err := c.RunOnce(ctx); err != nil {
+		c.log.Warnf("Error moving routes: %v", err)
+	}
+	if err := c.elector.Resign(ctx); err != nil {
+		c.log.Warnf("Unable to hand over leadership: %v", err)
+	}
+
+	for {
+		n, err := c.ownRoutes(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			c.log.Info("Drained")
+			return nil
+		}
+		if time.Now().Add(drainPollInterval).After(deadline) {
+			return errors.Errorf("%v RoutingTables still route through %v", n, c.nodeId)
+		}
+		c.log.Infof("Waiting for the leader to move %v RoutingTables", n)
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// tagDrain sets or clears the drain tag of the NAT Instance of this node, only NAT Instances in EC2 are tagged
+func (c *RouteController) tagDrain(ctx context.Context, drain bool) error {
+	if c.config.central || c.config.discovery == discoveryFile || c.nodeId == "" {
+		return nil
+	}
+	resources := []*string{aws.String(c.nodeId)}
+	if !drain {
+		_, err := c.ec2.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+			Resources: resources,
+			Tags:      []*ec2.Tag{{Key: aws.String(c.config.tags.Drain)}},
+		})
+		return err
+	}
+	_, err := c.ec2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: resources,
+		Tags: []*ec2.Tag{
+			{Key: aws.String(c.config.tags.Drain), Value: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	return err
+}
+
+// ownRoutes returns the number of Routing Tables routing through the NAT Instance of this node
+func (c *RouteController) ownRoutes(ctx context.Context) (int, error) {
+	f, err := c.ensureFinder()
+	if err != nil {
+		return 0, err
+	}
+	rts, err := f.FindRoutingTables(ctx, c.config.clusterId, c.config.vpcId)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rt := range rts {
+		if rt.EgressNatInstanceId == c.nodeId {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ensureFinder returns the Finder of the target, it is created by the first call
+func (c *RouteController) ensureFinder() (discover.Finder, error) {
+	if c.finder != nil {
+		return c.finder, nil
+	}
+	f, err := c.newFinder()
+	if err != nil {
+		return nil, err
+	}
+	c.finder = f
+	return f, nil
+}
+
+// newFinder returns the Finder for the discovery mode of the target
+func (c *RouteController) newFinder() (discover.Finder, error) {
+	switch c.config.discovery {
+	case discoveryFile:
+		inventory, err := discover.NewFileFinder(c.config.inventory)
+		if err != nil {
+			return nil, err
+		}
+		return inventory, nil
+	case discoveryAsg:
+		// asg-tag is KEY or KEY=VALUE
+		kv := strings.SplitN(c.config.asgTag, "=", 2)
+		key, value := kv[0], ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		return discover.NewAsgFinder(c.ec2, autoscaling.New(c.session, endpointConfig(c.config.endpoints.autoscaling)...), c.config.tags, c.config.asgNames, key, value)
+	}
+	return discover.NewAwsFinder(c.ec2, c.config.tags)
+}
+
+// newElector returns the Elector for the election mode of the target, watched by a Watchdog unless disabled
+func (c *RouteController) newElector() (election.Elector, error) {
+	var e election.Usurper
+	var heartbeats election.Heartbeats
+	switch c.config.election {
+	case electionOldest:
+		e = election.NewOldestElector(c.nodeId)
+		if port := c.statusPort(); port != "" {
+			heartbeats = &peerHeartbeats{port: port, target: c.config.name}
+		} else if c.config.watchdogIntervals != 0 {
+			c.log.Info("Watchdog disabled, oldest election reads the heartbeat of the leader from the status served on --status-addr")
+		}
+	case electionDynamoDB:
+		// one lease per vpc and cluster, target names may differ between nodes
+		lockId := fmt.Sprintf("%v/%v", c.config.vpcId, c.config.clusterId)
+		d, err := election.NewDynamoDBElectorFromSession(c.session, c.config.dynamodbTable, lockId, c.nodeId,
+			c.config.leaseTTL, endpointConfig(c.config.endpoints.dynamodb)...)
+		if err != nil {
+			return nil, err
+		}
+		e, heartbeats = d, d
+	case electionTags:
+		t, err := election.NewTagElector(c.ec2, c.config.tags, c.config.vpcId, c.config.clusterId, c.config.leaseResource,
+			c.nodeId, c.config.leaseTTL)
+		if err != nil {
+			return nil, err
+		}
+		e, heartbeats = t, t
+	default:
+		return election.AlwaysElector{}, nil
+	}
+	if c.config.watchdogIntervals == 0 || heartbeats == nil {
+		return e, nil
+	}
+	maxAge := time.Duration(c.config.watchdogIntervals) * c.config.reconcileInterval()
+	return election.NewWatchdog(e, heartbeats, c.nodeId, maxAge), nil
+}
+
+// statusPort returns the port peers serve their status on, blank unless the status is served
+func (c *RouteController) statusPort() string {
+	_, port, err := net.SplitHostPort(c.config.statusAddr)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// peerLeader returns the live NAT Instance which the first responding live peer considers leader,
+// blank if unknown or the status is not served
+func (c *RouteController) peerLeader(ctx context.Context, live []*discover.NatInstance) string {
+	port := c.statusPort()
+	if port == "" {
+		return ""
+	}
+	for _, ni := range live {
+		if ni.Id == c.nodeId {
+			continue
+		}
+		cycle, err := status.Get(ctx, net.JoinHostPort(ni.PrivateIP, port), c.config.name, peerStatusTimeout)
+		if err != nil {
+			c.log.Debugf("No leader from peer %q: %v", ni.Id, err)
+			continue
+		}
+		for _, l := range live {
+			if l.Id == cycle.Leader {
+				c.log.Infof("Following leader %q of peer %q", l.Id, ni.Id)
+				return l.Id
+			}
+		}
+	}
+	return ""
+}
+
+// peerHeartbeats reads the heartbeat of the oldest live NAT Instance from the status it serves,
+// every node should serve its status on the same port and name the target alike
+type peerHeartbeats struct {
+	port   string
+	target string
+}
+
+// LastHeartbeat returns the oldest live NAT Instance and the end of its last successful reconciliation as leader
+func (p *peerHeartbeats) LastHeartbeat(ctx context.Context, live []*discover.NatInstance) (string, time.Time, error) {
+	if len(live) == 0 {
+		return "", time.Time{}, nil
+	}
+	leader := live[0]
+	cycle, err := status.Get(ctx, net.JoinHostPort(leader.PrivateIP, p.port), p.target, peerStatusTimeout)
+	if err != nil {
+		// the NAT Instance may be alive while its router is not
+		log.Debugf("No heartbeat from leader %q: %v", leader.Id, err)
+		return leader.Id, time.Time{}, nil
+	}
+	if cycle.Role != roleActive || cycle.Error != "" {
+		return leader.Id, time.Time{}, nil
+	}
+	return leader.Id, cycle.Finished, nil
+}
+
+// newRouter returns the Router for the discovery mode of the target
+func (c *RouteController) newRouter() (router.Router, error) {
+	if inventory, ok := c.finder.(*discover.FileFinder); ok {
+		return inventory, nil
+	}
+	return router.NewAwsRouter(c.ec2)
+}
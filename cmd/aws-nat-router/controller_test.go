@@ -0,0 +1,683 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+	"github.com/so0k/aws-nat-router/pkg/events"
+	"github.com/so0k/aws-nat-router/pkg/fake"
+	"github.com/so0k/aws-nat-router/pkg/status"
+)
+
+var launched = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testVpc holds a RouteController for a fake vpc with a NAT Instance and a private routing table
+// in zones a and b, instances are healthy unless their address is marked down
+type testVpc struct {
+	rc   *RouteController
+	ec2  *fake.EC2
+	down map[string]bool
+}
+
+func newTestVpc(t *testing.T) *testVpc {
+	return newTestVpcWithTags(t, discover.DefaultTags)
+}
+
+// newTestVpcWithTags returns a testVpc whose resources are tagged with keys
+func newTestVpcWithTags(t *testing.T, keys discover.Tags) *testVpc {
+	f := fake.NewEC2()
+	f.AddZone("ap-southeast-1a", "apse1-az2")
+	f.AddZone("ap-southeast-1b", "apse1-az1")
+	f.AddSubnet("subnet-a", "vpc-1", "ap-southeast-1a")
+	f.AddSubnet("subnet-b", "vpc-1", "ap-southeast-1b")
+	tags := map[string]string{keys.ClusterId: "squid"}
+	f.AddInstance(fake.Instance{Id: "i-a", VpcId: "vpc-1", Zone: "ap-southeast-1a", PrivateIP: "10.0.1.10", LaunchTime: launched, Tags: tags})
+	f.AddInstance(fake.Instance{Id: "i-b", VpcId: "vpc-1", Zone: "ap-southeast-1b", PrivateIP: "10.0.2.10", LaunchTime: launched.Add(time.Hour), Tags: tags})
+	// rtb-a has no default route yet, rtb-b routes through the wrong zone
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-a", VpcId: "vpc-1", Subnets: []string{"subnet-a"}, Tags: tags})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-b", VpcId: "vpc-1", Subnets: []string{"subnet-b"}, Egress: "i-a", Tags: tags})
+
+	target := &targetConfig{
+		name:           "vpc-1/squid",
+		endpoints:      &endpoints{},
+		vpcId:          "vpc-1",
+		clusterId:      "squid",
+		tags:           keys,
+		discovery:      discoveryTags,
+		election:       electionNone,
+		leaderOrder:    election.OrderOldest,
+		port:           3128,
+		timeout:        50 * time.Millisecond,
+		interval:       10 * time.Second,
+		cycleTimeout:   30 * time.Second,
+		eligibleStates: map[string]bool{"running": true},
+	}
+	v := &testVpc{
+		ec2:  f,
+		down: make(map[string]bool),
+	}
+	var err error
+	v.rc, err = NewRouteController(target, "i-a", session.New(), status.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.rc.ec2 = f
+	v.rc.healthCheck = func(addr string, timeout time.Duration) error {
+		if v.down[addr] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	return v
+}
+
+// expectEgress fails the test if routing tables do not route through the wanted instances
+func (v *testVpc) expectEgress(t *testing.T, want map[string]string) {
+	t.Helper()
+	for rt, ni := range want {
+		if got := v.ec2.Egress(rt); got != ni {
+			t.Errorf("%v routes through %q, want %q", rt, got, ni)
+		}
+	}
+}
+
+func (v *testVpc) runOnce(t *testing.T) *status.Cycle {
+	t.Helper()
+	if err := v.rc.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	return v.rc.status.Last(v.rc.config.name)
+}
+
+func TestRunOnceAllocatesByZone(t *testing.T) {
+	v := newTestVpc(t)
+	cycle := v.runOnce(t)
+	if cycle.Role != roleActive {
+		t.Errorf("role %v, want %v", cycle.Role, roleActive)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-b"})
+	for _, id := range []string{"i-a", "i-b"} {
+		if v.ec2.SourceDestCheck(id) {
+			t.Errorf("%v SourceDestCheck still enabled", id)
+		}
+	}
+
+	// a converged vpc is left alone
+	calls := v.ec2.Calls("ReplaceRoute") + v.ec2.Calls("CreateRoute")
+	v.runOnce(t)
+	if got := v.ec2.Calls("ReplaceRoute") + v.ec2.Calls("CreateRoute"); got != calls {
+		t.Errorf("%v route updates for converged vpc, want none", got-calls)
+	}
+}
+
+func TestRunOnceCachesZones(t *testing.T) {
+	v := newTestVpc(t)
+	// rtb-c has no zone, the report holds it once per cycle
+	v.ec2.AddRouteTable(fake.RouteTable{Id: "rtb-c", VpcId: "vpc-1", Tags: map[string]string{discover.DefaultTags.ClusterId: "squid"}})
+	for i := 0; i < 2; i++ {
+		if cycle := v.runOnce(t); cycle.Report == nil || len(cycle.Report.Issues) != 1 {
+			t.Errorf("cycle %v reported %+v, want rtb-c", i, cycle.Report)
+		}
+	}
+	if got := v.ec2.Calls("DescribeAvailabilityZones"); got != 1 {
+		t.Errorf("DescribeAvailabilityZones called %v times, want once", got)
+	}
+}
+
+func TestRunOnceFailover(t *testing.T) {
+	v := newTestVpc(t)
+	v.runOnce(t)
+
+	v.down["10.0.1.10:3128"] = true
+	cycle := v.runOnce(t)
+	v.expectEgress(t, map[string]string{"rtb-a": "i-b", "rtb-b": "i-b"})
+	if len(cycle.Unhealthy) != 1 || cycle.Unhealthy[0] != "i-a" {
+		t.Errorf("unhealthy %v, want [i-a]", cycle.Unhealthy)
+	}
+
+	// routes return to their zone once the instance recovers
+	delete(v.down, "10.0.1.10:3128")
+	v.runOnce(t)
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-b"})
+}
+
+func TestRunOnceDrainsStoppingInstance(t *testing.T) {
+	v := newTestVpc(t)
+	v.runOnce(t)
+
+	v.ec2.SetInstanceState("i-b", "stopping")
+	cycle := v.runOnce(t)
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-a"})
+	if len(cycle.Draining) != 1 || cycle.Draining[0] != "i-b" {
+		t.Errorf("draining %v, want [i-b]", cycle.Draining)
+	}
+}
+
+func TestRunOnceRetriesFailedRoutes(t *testing.T) {
+	v := newTestVpc(t)
+	v.runOnce(t)
+
+	v.down["10.0.1.10:3128"] = true
+	throttled := awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+	v.ec2.Fail("ReplaceRoute", 1, throttled)
+	if err := v.rc.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce succeeded, want route update error")
+	}
+	if cycle := v.rc.status.Last(v.rc.config.name); cycle.Error == "" {
+		t.Error("cycle error not recorded")
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a"})
+
+	// the next cycle retries the failed route
+	v.runOnce(t)
+	v.expectEgress(t, map[string]string{"rtb-a": "i-b", "rtb-b": "i-b"})
+}
+
+func TestRunOnceDiscoveryError(t *testing.T) {
+	v := newTestVpc(t)
+	v.ec2.Fail("DescribeInstances", -1, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil))
+	if err := v.rc.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce succeeded, want discovery error")
+	}
+	if n := v.ec2.Calls("ReplaceRoute") + v.ec2.Calls("CreateRoute"); n != 0 {
+		t.Errorf("%v route updates without discovery, want none", n)
+	}
+
+	v.ec2.Recover("DescribeInstances")
+	v.runOnce(t)
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-b"})
+}
+
+func TestRunOnceTimesOut(t *testing.T) {
+	v := newTestVpc(t)
+	v.rc.config.cycleTimeout = 20 * time.Millisecond
+	v.ec2.Hang("DescribeInstances")
+	if err := v.rc.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce succeeded, want timeout")
+	}
+
+	v.ec2.Recover("DescribeInstances")
+	v.runOnce(t)
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-b"})
+}
+
+func TestRunOnceAbortsOnExpiredLease(t *testing.T) {
+	v := newTestVpc(t)
+	e, err := election.NewTagElector(v.ec2, discover.DefaultTags, "vpc-1", "squid", "", "i-a", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Settle = 0
+	v.rc.elector = e
+	// the route update hangs past the expiry of the lease
+	v.ec2.Hang("ReplaceRoute")
+	started := time.Now()
+	if err := v.rc.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce succeeded, want plan aborted")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("RunOnce returned after %v, want once the lease expired", elapsed)
+	}
+	if n := v.ec2.Calls("CreateRoute"); n != 0 {
+		t.Errorf("%v CreateRoute calls after the lease expired, want none", n)
+	}
+}
+
+func TestRunOnceReportsDegradedAndThrottled(t *testing.T) {
+	v := newTestVpc(t)
+	// rtb-a has no default route yet
+	if cycle := v.runOnce(t); !cycle.Degraded {
+		t.Error("cycle not degraded with a missing route")
+	}
+	if cycle := v.runOnce(t); cycle.Degraded {
+		t.Error("cycle degraded with every route up to date")
+	}
+
+	v.ec2.Fail("DescribeInstances", 1, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil))
+	if err := v.rc.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce succeeded, want throttling error")
+	}
+	if cycle := v.rc.status.Last(v.rc.config.name); !cycle.Throttled {
+		t.Error("cycle not throttled")
+	}
+}
+
+func TestRunOnceSpacesRouteUpdates(t *testing.T) {
+	v := newTestVpc(t)
+	v.rc.config.minMutationInterval = 100 * time.Millisecond
+	v.runOnce(t)
+	started := time.Now()
+	v.down["10.0.1.10:3128"] = true
+	v.runOnce(t)
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("routes updated again after %v, want at least the min mutation interval", elapsed)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-b", "rtb-b": "i-b"})
+}
+
+func TestSchedule(t *testing.T) {
+	s := newSchedule(&targetConfig{interval: 10 * time.Second, degradedInterval: 2 * time.Second})
+	for _, tc := range []struct {
+		cycle *status.Cycle
+		want  time.Duration
+	}{
+		{&status.Cycle{}, 10 * time.Second},
+		{&status.Cycle{Degraded: true}, 2 * time.Second},
+		// throttling backs off even while degraded
+		{&status.Cycle{Degraded: true, Throttled: true}, 20 * time.Second},
+		{&status.Cycle{Throttled: true}, 40 * time.Second},
+		{&status.Cycle{}, 10 * time.Second},
+	} {
+		if got := s.next(tc.cycle); got != tc.want {
+			t.Errorf("next(%+v) = %v, want %v", tc.cycle, got, tc.want)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		s.next(&status.Cycle{Throttled: true})
+	}
+	if got := s.next(&status.Cycle{Throttled: true}); got != maxBackoff {
+		t.Errorf("backoff %v, want at most %v", got, maxBackoff)
+	}
+	// a leader keeps renewing its lease
+	s = newSchedule(&targetConfig{interval: 10 * time.Second, election: electionTags, leaseTTL: time.Minute})
+	for i := 0; i < 10; i++ {
+		s.next(&status.Cycle{Throttled: true})
+	}
+	if got := s.next(&status.Cycle{Throttled: true}); got != 30*time.Second {
+		t.Errorf("backoff %v with a lease of 1m, want 30s", got)
+	}
+
+	s = newSchedule(&targetConfig{interval: 10 * time.Second, jitter: 0.1})
+	for i := 0; i < 100; i++ {
+		if got := s.next(&status.Cycle{}); got < 9*time.Second || got > 11*time.Second {
+			t.Fatalf("next = %v, want within 10%% of 10s", got)
+		}
+	}
+}
+
+func TestProbeDetectsTransitions(t *testing.T) {
+	v := newTestVpc(t)
+	v.runOnce(t)
+	if v.rc.probeOnce() {
+		t.Error("probe reported a transition without a change of health")
+	}
+
+	v.down["10.0.1.10:3128"] = true
+	if !v.rc.probeOnce() {
+		t.Fatal("probe missed i-a failing")
+	}
+	// the transition is reported once
+	if v.rc.probeOnce() {
+		t.Error("probe reported the same transition twice")
+	}
+
+	// the reconciliation it triggers fails over
+	v.runOnce(t)
+	v.expectEgress(t, map[string]string{"rtb-a": "i-b", "rtb-b": "i-b"})
+	delete(v.down, "10.0.1.10:3128")
+	if !v.rc.probeOnce() {
+		t.Error("probe missed i-a recovering")
+	}
+}
+
+func TestConcerns(t *testing.T) {
+	v := newTestVpc(t)
+	v.rc.config.region = "ap-southeast-1"
+	event := func(region, instanceId, state, routeTableId, vpcId string) *events.Event {
+		e := &events.Event{Region: region}
+		e.Detail.InstanceId = instanceId
+		e.Detail.State = state
+		e.Detail.RequestParameters.RouteTableId = routeTableId
+		e.Detail.RequestParameters.VpcId = vpcId
+		return e
+	}
+	// every event concerns a target before it discovered its resources
+	if !v.rc.Concerns(event("ap-southeast-1", "i-other", "stopped", "", "")) {
+		t.Error("event of an unknown instance ignored before discovery")
+	}
+	v.runOnce(t)
+	for _, c := range []struct {
+		e    *events.Event
+		want bool
+	}{
+		{event("ap-southeast-1", "i-a", "stopping", "", ""), true},
+		// i-other may replace a NAT Instance
+		{event("ap-southeast-1", "i-other", "pending", "", ""), true},
+		{event("ap-southeast-1", "i-other", "running", "", ""), true},
+		{event("ap-southeast-1", "i-other", "stopped", "", ""), false},
+		{event("us-east-1", "i-a", "stopping", "", ""), false},
+		{event("us-east-1", "i-other", "running", "", ""), false},
+		{event("ap-southeast-1", "", "", "rtb-b", ""), true},
+		{event("ap-southeast-1", "", "", "rtb-other", ""), false},
+		{event("ap-southeast-1", "", "", "", "vpc-1"), true},
+		{event("ap-southeast-1", "", "", "", "vpc-2"), false},
+		{event("ap-southeast-1", "", "", "", ""), true},
+	} {
+		if got := v.rc.Concerns(c.e); got != c.want {
+			t.Errorf("Concerns(%+v) = %v, want %v", c.e.Detail, got, c.want)
+		}
+	}
+}
+
+func TestRunOncePassiveWithElection(t *testing.T) {
+	v := newTestVpc(t)
+	// i-b is not the oldest live instance
+	v.rc.elector = election.NewOldestElector("i-b")
+	if cycle := v.runOnce(t); cycle.Role != rolePassive {
+		t.Errorf("role %v, want %v", cycle.Role, rolePassive)
+	}
+	if n := v.ec2.Calls("DescribeRouteTables"); n != 0 {
+		t.Errorf("%v DescribeRouteTables calls while passive, want none", n)
+	}
+
+	// i-b takes over once i-a fails
+	v.down["10.0.1.10:3128"] = true
+	if cycle := v.runOnce(t); cycle.Role != roleActive {
+		t.Errorf("role %v, want %v", cycle.Role, roleActive)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-b", "rtb-b": "i-b"})
+}
+
+func TestRunOnceTagElection(t *testing.T) {
+	v := newTestVpc(t)
+	newElector := func(holder string) election.Elector {
+		e, err := election.NewTagElector(v.ec2, discover.DefaultTags, "vpc-1", "squid", "", holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Settle = 0
+		return e
+	}
+	v.rc.elector = newElector("i-a")
+	if cycle := v.runOnce(t); cycle.Role != roleActive {
+		t.Errorf("role %v, want %v", cycle.Role, roleActive)
+	}
+	if v.ec2.Tag("rtb-a", discover.DefaultTags.Heartbeat) == "" {
+		t.Error("no heartbeat after successful RunOnce")
+	}
+
+	// i-b is passive while i-a holds the lease, whatever its view of health
+	v.down["10.0.1.10:3128"] = true
+	v.rc.elector = newElector("i-b")
+	if cycle := v.runOnce(t); cycle.Role != rolePassive {
+		t.Errorf("role %v, want %v", cycle.Role, rolePassive)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-b"})
+}
+
+func TestRenewLease(t *testing.T) {
+	v := newTestVpc(t)
+	newElector := func(holder string) *election.TagElector {
+		e, err := election.NewTagElector(v.ec2, discover.DefaultTags, "vpc-1", "squid", "", holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Settle = 0
+		return e
+	}
+	a := newElector("i-a")
+	v.rc.elector = a
+	if v.rc.renewLease() {
+		t.Error("lease lost before it was acquired")
+	}
+	v.runOnce(t)
+	expires := a.Expires()
+	time.Sleep(10 * time.Millisecond)
+	if v.rc.renewLease() {
+		t.Fatal("lease lost while held")
+	}
+	if !a.Expires().After(expires) {
+		t.Errorf("lease expires at %v, not renewed after %v", a.Expires(), expires)
+	}
+
+	if _, err := newElector("i-b").Usurp(context.Background(), "i-a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !v.rc.renewLease() {
+		t.Error("renewal missed the lease taken over by i-b")
+	}
+}
+
+func TestRunOnceWatchdogTakeover(t *testing.T) {
+	v := newTestVpc(t)
+	newElector := func(holder string) *election.TagElector {
+		e, err := election.NewTagElector(v.ec2, discover.DefaultTags, "vpc-1", "squid", "", holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Settle = 0
+		return e
+	}
+	v.rc.elector = newElector("i-a")
+	v.runOnce(t)
+
+	// i-a stops reconciling while its NAT Instance stays healthy
+	b := newElector("i-b")
+	v.rc.elector = election.NewWatchdog(b, b, "i-b", 20*time.Millisecond)
+	if cycle := v.runOnce(t); cycle.Role != rolePassive {
+		t.Fatalf("role %v, want %v", cycle.Role, rolePassive)
+	}
+	time.Sleep(30 * time.Millisecond)
+	cycle := v.runOnce(t)
+	if cycle.Role != roleActive {
+		t.Fatalf("role %v, want %v", cycle.Role, roleActive)
+	}
+	if cycle.Takeover == nil || cycle.Takeover.From != "i-a" {
+		t.Errorf("takeover %+v, want from i-a", cycle.Takeover)
+	}
+	if got := v.ec2.Tag("rtb-a", discover.DefaultTags.LeaseHolder); got != "i-b" {
+		t.Errorf("lease held by %q, want i-b", got)
+	}
+}
+
+// pausingElector lets another node take over after electing this node, as if it paused before applying its plan
+type pausingElector struct {
+	*election.TagElector
+	pause func()
+}
+
+func (e *pausingElector) IsLeader(ctx context.Context, live []*discover.NatInstance) (bool, error) {
+	leader, err := e.TagElector.IsLeader(ctx, live)
+	e.pause()
+	return leader, err
+}
+
+func TestRunOnceAbortsStalePlan(t *testing.T) {
+	v := newTestVpc(t)
+	newElector := func(holder string) *election.TagElector {
+		e, err := election.NewTagElector(v.ec2, discover.DefaultTags, "vpc-1", "squid", "", holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Settle = 0
+		return e
+	}
+	b := newElector("i-b")
+	v.rc.elector = &pausingElector{
+		TagElector: newElector("i-a"),
+		pause: func() {
+			if _, err := b.Usurp(context.Background(), "i-a", nil); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	err := v.rc.RunOnce(context.Background())
+	if errors.Cause(err) != election.ErrStaleTerm {
+		t.Fatalf("RunOnce = %v, want %v", err, election.ErrStaleTerm)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "", "rtb-b": "i-a"})
+	if cycle := v.rc.status.Last(v.rc.config.name); cycle.Term != 1 {
+		t.Errorf("term %v, want 1", cycle.Term)
+	}
+}
+
+func TestRunOnceStickyLeader(t *testing.T) {
+	v := newTestVpc(t)
+	v.rc.config.election = electionOldest
+	v.rc.config.stickyLeader = true
+	v.rc.nodeId = "i-b"
+	v.rc.elector = election.NewOldestElector("i-b")
+
+	// i-b leads while the older i-a is down
+	v.down["10.0.1.10:3128"] = true
+	if cycle := v.runOnce(t); cycle.Role != roleActive || cycle.Leader != "i-b" {
+		t.Fatalf("role %v with leader %q, want %v with leader i-b", cycle.Role, cycle.Leader, roleActive)
+	}
+
+	// and keeps leading once i-a recovers
+	delete(v.down, "10.0.1.10:3128")
+	if cycle := v.runOnce(t); cycle.Role != roleActive || cycle.Leader != "i-b" {
+		t.Errorf("role %v with leader %q, want %v with leader i-b", cycle.Role, cycle.Leader, roleActive)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-b"})
+
+	// without stickiness leadership flips back to i-a
+	v.rc.config.stickyLeader = false
+	if cycle := v.runOnce(t); cycle.Role != rolePassive || cycle.Leader != "i-a" {
+		t.Errorf("role %v with leader %q, want %v with leader i-a", cycle.Role, cycle.Leader, rolePassive)
+	}
+}
+
+func TestRunOnceCentralReplicas(t *testing.T) {
+	v := newTestVpc(t)
+	v.rc.config.election = electionTags
+	v.rc.config.leaseTTL = time.Minute
+	replica := func(id string) *RouteController {
+		rc, err := NewRouteController(v.rc.config, id, session.New(), status.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.ec2, rc.healthCheck = v.ec2, v.rc.healthCheck
+		e, err := rc.newElector()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.(*election.TagElector).Settle = 0
+		rc.elector = e
+		return rc
+	}
+	for _, r := range []struct {
+		rc   *RouteController
+		role string
+	}{
+		{replica("ctl-1"), roleActive},
+		{replica("ctl-2"), rolePassive},
+	} {
+		if err := r.rc.RunOnce(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if cycle := r.rc.status.Last(r.rc.config.name); cycle.Role != r.role {
+			t.Errorf("%v role %v, want %v", r.rc.nodeId, cycle.Role, r.role)
+		}
+	}
+	if got := v.ec2.Tag("rtb-a", discover.DefaultTags.LeaseHolder); got != "ctl-1" {
+		t.Errorf("lease held by %q, want ctl-1", got)
+	}
+}
+
+func TestShutdownDrainsLeader(t *testing.T) {
+	v := newTestVpc(t)
+	go v.rc.Run()
+	if err := v.rc.Shutdown(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-b", "rtb-b": "i-b"})
+	if v.ec2.Tag("i-a", discover.DefaultTags.Drain) == "" {
+		t.Error("i-a not tagged to drain")
+	}
+}
+
+func TestShutdownDrainsOldestLeader(t *testing.T) {
+	v := newTestVpc(t)
+	v.rc.config.election = electionOldest
+	v.rc.elector = election.NewOldestElector("i-a")
+	go v.rc.Run()
+	// i-a leads as the oldest NAT Instance and moves its own routes
+	if err := v.rc.Shutdown(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-b", "rtb-b": "i-b"})
+}
+
+func TestRunOnceDrainsTaggedPeer(t *testing.T) {
+	v := newTestVpc(t)
+	v.runOnce(t)
+	// the router on i-b shuts down
+	v.ec2.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String("i-b")},
+		Tags:      []*ec2.Tag{{Key: aws.String(discover.DefaultTags.Drain), Value: aws.String("2018-01-01T00:00:00Z")}},
+	})
+	cycle := v.runOnce(t)
+	if len(cycle.Draining) != 1 || cycle.Draining[0] != "i-b" {
+		t.Errorf("draining %v, want i-b", cycle.Draining)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-a"})
+}
+
+func TestRunOnceCustomTags(t *testing.T) {
+	keys := discover.Tags{
+		ClusterId:    "nat/id",
+		Zone:         "nat/zone",
+		LeaseHolder:  "nat/holder",
+		LeaseTerm:    "nat/term",
+		LeaseExpires: "nat/expires",
+		Heartbeat:    "nat/heartbeat",
+		Fence:        "nat/fence",
+		Priority:     "nat/priority",
+		Drain:        "nat/drain",
+	}
+	v := newTestVpcWithTags(t, keys)
+	e, err := election.NewTagElector(v.ec2, keys, "vpc-1", "squid", "", "i-a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Settle = 0
+	v.rc.elector = e
+
+	// NAT Instances and Routing Tables are discovered by the custom cluster id key
+	if cycle := v.runOnce(t); cycle.Role != roleActive || cycle.Term != 1 {
+		t.Fatalf("role %v in term %v, want %v in term 1", cycle.Role, cycle.Term, roleActive)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-b"})
+	for key, want := range map[string]string{
+		keys.LeaseHolder:                 "i-a",
+		keys.LeaseTerm:                   "1",
+		keys.Fence:                       "1",
+		discover.DefaultTags.LeaseHolder: "",
+		discover.DefaultTags.Fence:       "",
+	} {
+		if got := v.ec2.Tag("rtb-a", key); got != want {
+			t.Errorf("rtb-a tagged %v=%q, want %q", key, got, want)
+		}
+	}
+	if v.ec2.Tag("rtb-a", keys.LeaseExpires) == "" || v.ec2.Tag("rtb-a", keys.Heartbeat) == "" {
+		t.Error("lease expiry or heartbeat not tagged with the custom keys")
+	}
+
+	if err := v.rc.tagDrain(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if v.ec2.Tag("i-a", keys.Drain) == "" || v.ec2.Tag("i-a", discover.DefaultTags.Drain) != "" {
+		t.Error("drain not requested with the custom key")
+	}
+	if err := v.rc.tagDrain(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	// a peer is drained by the custom key
+	v.ec2.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String("i-b")},
+		Tags:      []*ec2.Tag{{Key: aws.String(keys.Drain), Value: aws.String("2018-01-01T00:00:00Z")}},
+	})
+	if cycle := v.runOnce(t); len(cycle.Draining) != 1 || cycle.Draining[0] != "i-b" {
+		t.Errorf("draining %v, want i-b", cycle.Draining)
+	}
+	v.expectEgress(t, map[string]string{"rtb-a": "i-a", "rtb-b": "i-a"})
+}
@@ -1,22 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"sort"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/so0k/aws-nat-router/pkg/discover"
-	"github.com/so0k/aws-nat-router/pkg/healthcheck"
-	"github.com/so0k/aws-nat-router/pkg/router"
+	"github.com/so0k/aws-nat-router/pkg/election"
+	"github.com/so0k/aws-nat-router/pkg/events"
+	"github.com/so0k/aws-nat-router/pkg/status"
 	"github.com/urfave/cli"
 )
 
@@ -38,12 +36,48 @@ func main() {
 			Name:  "aws-secret-key",
 			Usage: "Optional aws secret key to use",
 		},
+		cli.StringFlag{
+			Name:   "aws-profile",
+			Usage:  "Optional shared credentials `PROFILE` to use",
+			EnvVar: "AWS_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "aws-web-identity-token-file",
+			Usage:  "Optional web identity token `FILE` to exchange for credentials of --aws-web-identity-role-arn",
+			EnvVar: "AWS_WEB_IDENTITY_TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "aws-web-identity-role-arn",
+			Usage:  "`ARN` of the role to assume with the web identity token",
+			EnvVar: "AWS_ROLE_ARN",
+		},
+		cli.StringFlag{
+			Name:   "aws-role-arn",
+			Usage:  "Optional `ARN` of a role to assume, e.g. to manage routes in another account",
+			EnvVar: "NAT_AWS_ROLE_ARN",
+		},
+		cli.StringFlag{
+			Name:   "aws-external-id",
+			Usage:  "Optional external `ID` to use when assuming --aws-role-arn",
+			EnvVar: "NAT_AWS_EXTERNAL_ID",
+		},
+		cli.StringFlag{
+			Name:   "aws-role-session-name",
+			Value:  "aws-nat-router",
+			Usage:  "`NAME` of the session when assuming a role",
+			EnvVar: "NAT_AWS_ROLE_SESSION_NAME",
+		},
 		cli.StringFlag{
 			Name:   "region,r",
 			Value:  "ap-southeast-1",
 			Usage:  "AWS `REGION`",
 			EnvVar: "AWS_REGION",
 		},
+		cli.StringFlag{
+			Name:   "config,c",
+			Usage:  "Optional `FILE` listing the targets (VPC, cluster ID and settings) to reconcile, flags provide the defaults",
+			EnvVar: "NAT_CONFIG",
+		},
 		cli.StringFlag{
 			Name:   "vpc-id",
 			Usage:  "Required `ID` of the VPC the NAT Instances live in",
@@ -55,17 +89,151 @@ func main() {
 			Usage:  "`ID` the NAT Instances are tagged with",
 			EnvVar: "NAT_CLUSTER_ID",
 		},
+		cli.StringFlag{
+			Name:   "discovery",
+			Value:  discoveryTags,
+			Usage:  "`MODE` to discover NAT Instances with: tags, asg or file",
+			EnvVar: "NAT_DISCOVERY",
+		},
+		cli.StringFlag{
+			Name:   "asg-names",
+			Usage:  "Comma separated `NAMES` of the Auto Scaling groups of the NAT Instances when using asg discovery",
+			EnvVar: "NAT_ASG_NAMES",
+		},
+		cli.StringFlag{
+			Name:   "asg-tag",
+			Usage:  "`KEY[=VALUE]` of the tag on the Auto Scaling groups of the NAT Instances when using asg discovery",
+			EnvVar: "NAT_ASG_TAG",
+		},
+		cli.StringFlag{
+			Name:   "inventory",
+			Usage:  "`FILE` listing NAT Instances and Routing Tables when using file discovery, routes are applied in memory",
+			EnvVar: "NAT_INVENTORY",
+		},
+		cli.StringFlag{
+			Name:   "tag-cluster-id",
+			Value:  discover.DefaultTags.ClusterId,
+			Usage:  "`KEY` of the tag holding the cluster ID of NAT Instances and Routing Tables",
+			EnvVar: "NAT_TAG_CLUSTER_ID",
+		},
+		cli.StringFlag{
+			Name:   "tag-zone",
+			Value:  discover.DefaultTags.Zone,
+			Usage:  "`KEY` of the tag overriding the zone of NAT Instances and Routing Tables",
+			EnvVar: "NAT_TAG_ZONE",
+		},
+		cli.StringFlag{
+			Name:   "tag-lease-holder",
+			Value:  discover.DefaultTags.LeaseHolder,
+			Usage:  "`KEY` of the tag holding the instance ID of the leader when using tags election",
+			EnvVar: "NAT_TAG_LEASE_HOLDER",
+		},
+		cli.StringFlag{
+			Name:   "tag-lease-term",
+			Value:  discover.DefaultTags.LeaseTerm,
+			Usage:  "`KEY` of the tag holding the term of the lease when using tags election",
+			EnvVar: "NAT_TAG_LEASE_TERM",
+		},
+		cli.StringFlag{
+			Name:   "tag-lease-expires",
+			Value:  discover.DefaultTags.LeaseExpires,
+			Usage:  "`KEY` of the tag holding the expiry of the lease when using tags election",
+			EnvVar: "NAT_TAG_LEASE_EXPIRES",
+		},
+		cli.StringFlag{
+			Name:   "tag-heartbeat",
+			Value:  discover.DefaultTags.Heartbeat,
+			Usage:  "`KEY` of the tag holding the time of the last successful reconciliation of the leader when using tags election",
+			EnvVar: "NAT_TAG_HEARTBEAT",
+		},
+		cli.StringFlag{
+			Name:   "tag-fence",
+			Value:  discover.DefaultTags.Fence,
+			Usage:  "`KEY` of the tag holding the latest leadership term which updated a Routing Table when using dynamodb or tags election",
+			EnvVar: "NAT_TAG_FENCE",
+		},
+		cli.StringFlag{
+			Name:   "tag-priority",
+			Value:  discover.DefaultTags.Priority,
+			Usage:  "`KEY` of the tag holding the priority of a NAT Instance when using --leader-order priority",
+			EnvVar: "NAT_TAG_PRIORITY",
+		},
+		cli.StringFlag{
+			Name:   "tag-drain",
+			Value:  discover.DefaultTags.Drain,
+			Usage:  "`KEY` of the tag a router sets on its NAT Instance on shutdown to have its routes moved",
+			EnvVar: "NAT_TAG_DRAIN",
+		},
 		cli.DurationFlag{
 			Name:   "interval",
 			Value:  10 * time.Second,
 			Usage:  "`DURATION` Interval for evaluating NAT Instances and updating routes",
 			EnvVar: "NAT_INTERVAL",
 		},
+		cli.StringFlag{
+			Name:   "election",
+			Value:  electionNone,
+			Usage:  "`MODE` to elect the node which updates routes with: none, oldest (oldest live NAT Instance), dynamodb or tags (leases)",
+			EnvVar: "NAT_ELECTION",
+		},
 		cli.BoolFlag{
 			Name:   "ec2-election",
-			Usage:  "Use EC2 metadata leader election",
+			Usage:  "Alias of --election oldest",
 			EnvVar: "NAT_EC2_ELECTION",
 		},
+		cli.StringFlag{
+			Name:   "instance-id",
+			Usage:  "Optional `ID` of the NAT Instance this router runs on, overriding instance metadata, e.g. to test elections locally",
+			EnvVar: "NAT_INSTANCE_ID",
+		},
+		cli.BoolFlag{
+			Name:   "central",
+			Usage:  "Run as a central controller from any host, replicas elect a leader with dynamodb or tags election",
+			EnvVar: "NAT_CENTRAL",
+		},
+		cli.StringFlag{
+			Name:   "controller-id",
+			Usage:  "`ID` of this central controller replica in elections, defaults to the hostname",
+			EnvVar: "NAT_CONTROLLER_ID",
+		},
+		cli.StringFlag{
+			Name:   "leader-order",
+			Value:  election.OrderOldest,
+			Usage:  "`ORDER` ranking live NAT Instances for oldest election: oldest, lowest-id or priority (highest priority tag first)",
+			EnvVar: "NAT_LEADER_ORDER",
+		},
+		cli.BoolTFlag{
+			Name:   "prefer-on-demand",
+			Usage:  "Rank on-demand NAT Instances before Spot Instances for oldest election, set to false to rank them alike",
+			EnvVar: "NAT_PREFER_ON_DEMAND",
+		},
+		cli.BoolFlag{
+			Name:   "sticky-leader",
+			Usage:  "Keep the current leader while it is live rather than handing over to a higher ranked NAT Instance, for oldest election",
+			EnvVar: "NAT_STICKY_LEADER",
+		},
+		cli.StringFlag{
+			Name:   "dynamodb-table",
+			Usage:  "DynamoDB `TABLE` holding the leases when using dynamodb election, keyed by the string LockId",
+			EnvVar: "NAT_DYNAMODB_TABLE",
+		},
+		cli.StringFlag{
+			Name:   "lease-resource",
+			Usage:  "Optional `ID` of the EC2 resource holding the lease when using tags election, defaults to the managed Routing Table holding the lease or with the lowest id",
+			EnvVar: "NAT_LEASE_RESOURCE",
+		},
+		cli.IntFlag{
+			Name:   "watchdog-intervals",
+			Value:  3,
+			Usage:  "`N` reconciliations a passive node waits for the heartbeat of a live leader before taking over, 0 to disable",
+			EnvVar: "NAT_WATCHDOG_INTERVALS",
+		},
+		cli.DurationFlag{
+			Name:   "lease-ttl",
+			Value:  30 * time.Second,
+			Usage:  "`DURATION` a lease is held after each renewal when using dynamodb or tags election",
+			EnvVar: "NAT_LEASE_TTL",
+		},
 		cli.BoolFlag{
 			Name:   "public",
 			Usage:  "Use Public IPs for health checks",
@@ -83,6 +251,99 @@ func main() {
 			Usage:  "`DURATION` before HealthChecks time out",
 			EnvVar: "NAT_HC_TIMEOUT",
 		},
+		cli.StringFlag{
+			Name:   "eligible-states",
+			Value:  "running",
+			Usage:  "Comma separated `STATES` of NAT Instances eligible for routes",
+			EnvVar: "NAT_ELIGIBLE_STATES",
+		},
+		cli.StringFlag{
+			Name:   "sqs-queue-url",
+			Usage:  "Optional `URL` of an SQS queue receiving EC2 state-change and route table change events",
+			EnvVar: "NAT_SQS_QUEUE_URL",
+		},
+		cli.StringFlag{
+			Name:   "sqs-endpoint",
+			Usage:  "Optional SQS `ENDPOINT`, e.g. to use a local SQS-compatible stand-in",
+			EnvVar: "NAT_SQS_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "ec2-endpoint",
+			Usage:  "Optional EC2 `ENDPOINT`, e.g. to use LocalStack or moto in integration tests",
+			EnvVar: "NAT_EC2_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "autoscaling-endpoint",
+			Usage:  "Optional Auto Scaling `ENDPOINT`, e.g. to use LocalStack or moto in integration tests",
+			EnvVar: "NAT_AUTOSCALING_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "sts-endpoint",
+			Usage:  "Optional STS `ENDPOINT` used to assume roles, e.g. to use LocalStack or moto in integration tests",
+			EnvVar: "NAT_STS_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "dynamodb-endpoint",
+			Usage:  "Optional DynamoDB `ENDPOINT`, e.g. to use DynamoDB Local in integration tests",
+			EnvVar: "NAT_DYNAMODB_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "metadata-endpoint",
+			Usage:  "Optional EC2 instance metadata `ENDPOINT`, e.g. http://localhost:1338/latest to use a fake IMDS",
+			EnvVar: "NAT_METADATA_ENDPOINT",
+		},
+		cli.BoolFlag{
+			Name:   "imdsv1-fallback",
+			Usage:  "Fall back to IMDSv1 when no IMDSv2 token can be retrieved from the instance metadata service",
+			EnvVar: "NAT_IMDSV1_FALLBACK",
+		},
+		cli.DurationFlag{
+			Name:   "safety-interval",
+			Value:  time.Minute,
+			Usage:  "`DURATION` Interval for evaluating NAT Instances and updating routes when events are consumed",
+			EnvVar: "NAT_SAFETY_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "cycle-timeout",
+			Value:  30 * time.Second,
+			Usage:  "`DURATION` before a reconciliation is abandoned and its AWS calls cancelled",
+			EnvVar: "NAT_CYCLE_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "probe-interval",
+			Value:  500 * time.Millisecond,
+			Usage:  "`DURATION` Interval for health checking NAT Instances between reconciliations, a change of health reconciles immediately, 0 to disable",
+			EnvVar: "NAT_PROBE_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "degraded-interval",
+			Value:  2 * time.Second,
+			Usage:  "`DURATION` Interval for reconciling while Routing Tables route through dead or stopped NAT Instances, 0 to keep the interval",
+			EnvVar: "NAT_DEGRADED_INTERVAL",
+		},
+		cli.Float64Flag{
+			Name:   "jitter",
+			Value:  0.1,
+			Usage:  "`FRACTION` of the interval to randomize reconciliations by, so nodes do not reconcile in lockstep",
+			EnvVar: "NAT_JITTER",
+		},
+		cli.DurationFlag{
+			Name:   "min-mutation-interval",
+			Value:  2 * time.Second,
+			Usage:  "Minimum `DURATION` between two updates of the routes of a target",
+			EnvVar: "NAT_MIN_MUTATION_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "shutdown-timeout",
+			Value:  30 * time.Second,
+			Usage:  "`DURATION` to drain the NAT Instance and hand over leadership on SIGINT or SIGTERM",
+			EnvVar: "NAT_SHUTDOWN_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "status-addr",
+			Usage:  "Optional `ADDRESS` to serve the reconciliation status on, e.g. :8080",
+			EnvVar: "NAT_STATUS_ADDR",
+		},
 	}
 	app := cli.NewApp()
 	app.Name = "aws-nat-router"
@@ -99,184 +360,128 @@ func main() {
 	}
 }
 
-type config struct {
-	awsAccessKey string
-	awsSecretKey string
-	awsRoleARN   string
-	region       string
-	vpcId        string
-	clusterId    string
-	ec2Election  bool
-	instanceId   string
-	public       bool
-	port         int
-	timeout      time.Duration
-	interval     time.Duration
-}
-
-func parseConfig(c *cli.Context) (*config, error) {
-	conf := &config{
-		awsSecretKey: c.String("aws-secret-key"),
-		awsAccessKey: c.String("aws-access-key"),
-		awsRoleARN:   c.String("aws-role-arn"),
-		region:       c.String("region"),
-		vpcId:        c.String("vpc-id"),
-		clusterId:    c.String("cluster-id"),
-		ec2Election:  c.Bool("ec2-election"),
-		interval:     c.Duration("interval"),
-		public:       c.Bool("public"),
-		port:         c.Int("port"),
-		timeout:      c.Duration("timeout"),
-	}
-	lStr := c.String("log-level")
-	l, err := log.ParseLevel(lStr)
-	if err != nil {
-		return nil, err
-	}
-	log.SetLevel(l)
-
-	// validate vpc-id
-	if len(conf.vpcId) == 0 {
-		return nil, errors.New("vpc-id can not be blank")
-	}
-
-	if conf.interval < time.Second {
-		return nil, errors.New("Interval should not be less than 1 second")
-	}
-
-	//TODO: validate region?
-
-	return conf, nil
-}
-
 func run(c *cli.Context) error {
 	appConf, err := parseConfig(c)
 	if err != nil {
 		log.Error(err)
 		cli.ShowAppHelpAndExit(c, 1)
 	}
-	conf := initAwsConfig(appConf.awsAccessKey, appConf.awsSecretKey, appConf.region)
-	session := session.New(conf)
+	session := session.New(initAwsConfig(appConf))
 
-	i, err := discover.NewAwsIdentifierFromSession(session)
+	var i discover.Identifier
+	switch {
+	case appConf.central:
+		log.Infof("Running as central controller %q", appConf.controllerId)
+		i, err = discover.NewStaticIdentifier(appConf.controllerId)
+	case appConf.instanceId != "":
+		i, err = discover.NewStaticIdentifier(appConf.instanceId)
+	default:
+		i, err = discover.NewIMDSIdentifier(session, appConf.imdsV1Fallback, endpointConfig(appConf.endpoints.metadata)...)
+	}
 	if err != nil {
 		return err
 	}
-	appConf.instanceId, err = i.GetIdentity()
-	if err != nil && appConf.ec2Election {
-		log.Error("EC2 Election requested but not possible, disable --ec2-election")
-		log.Error(err)
-		cli.ShowAppHelpAndExit(c, 1)
-	}
-
-	rc := &RouteController{
-		config:  appConf,
-		session: session,
+	nodeId, err := i.GetIdentity(context.Background())
+	if err != nil {
+		for _, t := range appConf.targets {
+			if t.election != electionNone {
+				log.Errorf("Election %v requested for target %q but the instance identity is unknown, use --instance-id, --central or --election none", t.election, t.name)
+				log.Error(err)
+				cli.ShowAppHelpAndExit(c, 1)
+			}
+		}
 	}
 
-	// start control loop
-	return rc.Run()
-}
-
-type RouteController struct {
-	config  *config
-	session *session.Session
-}
-
-func (c *RouteController) Run() error {
-	for {
-		err := c.RunOnce()
+	st := status.New()
+	var rcs []*RouteController
+	for _, t := range appConf.targets {
+		rc, err := NewRouteController(t, nodeId, newTargetSession(session, t), st)
 		if err != nil {
-			log.Warnf("Error updating routes: %v", err)
+			return err
 		}
-		time.Sleep(c.config.interval)
-	}
-}
-
-func (c *RouteController) RunOnce() error {
-	log.Info("Reconciliation started")
-	f, err := discover.NewAwsFinderFromSession(c.session)
-	if err != nil {
-		return err
-	}
-	nis, err := f.FindNatInstances(c.config.clusterId, c.config.vpcId)
-	if err != nil {
-		return err
+		rcs = append(rcs, rc)
 	}
 
-	// Check liveness for each instance
-	var liveNis, deadNis []*discover.NatInstance
-	for _, ni := range nis {
-		var addr string
-		if c.config.public {
-			addr = fmt.Sprintf("%v:%v", ni.PublicIP, c.config.port)
-		} else {
-			addr = fmt.Sprintf("%v:%v", ni.PrivateIP, c.config.port)
-		}
-		err := healthcheck.TCPCheck(addr, c.config.timeout)
+	// stopEvents stops consuming events on shutdown
+	stopEvents := func() {}
+	if appConf.sqsQueueURL != "" {
+		consumer, err := events.NewSQSConsumerFromSession(session, appConf.sqsQueueURL, endpointConfig(appConf.endpoints.sqs)...)
 		if err != nil {
-			log.Debugf("Instance %q (%v) is dead :(", ni.Id, addr)
-			log.Debugf("\tError for TCPCheck: %v", err)
-			deadNis = append(deadNis, ni)
-		} else {
-			log.Debugf("Instance %q (%v) is alive!", ni.Id, addr)
-			liveNis = append(liveNis, ni)
-			// sorted by LaunchTime
-			sort.Slice(liveNis, func(i, j int) bool {
-				return liveNis[i].LaunchTime.Before(liveNis[j].LaunchTime)
-			})
+			return err
 		}
+		// routes updated by this node need no reconciliation, API calls are recognised by role session name
+		consumer.Ignore(nodeId)
+		if appConf.webIdentityTokenFile != "" {
+			consumer.Ignore(appConf.roleSessionName)
+		}
+		for _, rc := range rcs {
+			rc.triggers = make(chan struct{}, 1)
+			if rc.config.roleARN != "" {
+				consumer.Ignore(rc.config.roleSessionName)
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		stopEvents = cancel
+		go consumer.Run(ctx)
+		// route events to the targets they concern
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-consumer.Events():
+					for _, rc := range rcs {
+						if rc.Concerns(e) {
+							rc.Trigger()
+						}
+					}
+				}
+			}
+		}()
 	}
 
-	log.Infof("Healthy NAT Instances found: %v", len(liveNis))
-	if len(liveNis) > 0 && (!c.config.ec2Election || liveNis[0].Id == c.config.instanceId) {
-		log.Info("ACTIVE")
-		rts, _ := f.FindRoutingTables(c.config.clusterId, c.config.vpcId)
-
-		// Rebuild allocation based on discovered information
-		oldNias := router.GetCurrentAllocation(liveNis, rts)
+	if appConf.statusAddr != "" {
+		go func() {
+			if err := status.ListenAndServe(appConf.statusAddr, st); err != nil {
+				log.Errorf("Unable to serve status: %v", err)
+			}
+		}()
+	}
 
-		// Allocate routes to live NATInstances
-		newNias := router.AllocateRoutes(liveNis, rts)
+	// start a control loop per target
+	errs := make(chan error, len(rcs))
+	for _, rc := range rcs {
+		go func(rc *RouteController) {
+			errs <- rc.Run()
+		}(rc)
+	}
 
-		// Verify if allocation differs to avoid exceeding API rate limits
-		if router.AllocationDiffers(oldNias, newNias) {
-			log.Info("Updating Routes and Source Destination Checks ... ")
-			// Apply Allocations and ensure SourceDestCheck is disabled
-			r, _ := router.NewAwsRouterFromSession(c.session)
-			for _, nia := range newNias {
-				r.PreventSourceDestCheck(nia.NatInstance)
-				for _, rt := range nia.RoutingTables {
-					// hardcoding egress = 0.0.0.0/0
-					r.UpsertNatRoute("0.0.0.0/0", nia.NatInstance, rt)
-				}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Infof("Received %v, shutting down within %v", sig, appConf.shutdownTimeout)
+	}
+	// a second signal skips draining
+	go func() {
+		sig := <-signals
+		log.Warnf("Received %v while shutting down, exiting without draining", sig)
+		os.Exit(1)
+	}()
+	stopEvents()
+	deadline := time.Now().Add(appConf.shutdownTimeout)
+	var wg sync.WaitGroup
+	for _, rc := range rcs {
+		wg.Add(1)
+		go func(rc *RouteController) {
+			defer wg.Done()
+			if err := rc.Shutdown(deadline); err != nil {
+				rc.log.Errorf("Shutdown: %v", err)
 			}
-		} else {
-			log.Info("Routes are already up to date")
-		}
-	} else {
-		log.Info("PASSIVE")
+		}(rc)
 	}
-	// TODO(so0k): start recovery for unhealthy instances (just issue command... on next iteration Nat Instances will be re-evaluated)
+	wg.Wait()
 	return nil
 }
-
-func initAwsConfig(accessKey, secretKey, region string) *aws.Config {
-	awsConfig := aws.NewConfig()
-	creds := credentials.NewChainCredentials([]credentials.Provider{
-		&credentials.StaticProvider{
-			Value: credentials.Value{
-				AccessKeyID:     accessKey,
-				SecretAccessKey: secretKey,
-			},
-		},
-		&credentials.EnvProvider{},
-		&credentials.SharedCredentialsProvider{},
-		&ec2rolecreds.EC2RoleProvider{
-			Client: ec2metadata.New(session.New()),
-		},
-	})
-	awsConfig.WithCredentials(creds)
-	awsConfig.WithRegion(region)
-	return awsConfig
-}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// probeTarget is a NAT Instance health checked between reconciliations
+type probeTarget struct {
+	id      string
+	addr    string
+	healthy bool
+}
+
+// probe health checks the NAT Instances of the last reconciliation every probe interval until Shutdown is called,
+// a change of health triggers a reconciliation without waiting for the interval
+func (c *RouteController) probe() {
+	ticker := time.NewTicker(c.config.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if c.probeOnce() {
+			select {
+			case c.transitions <- struct{}{}:
+			default:
+				// a reconciliation is already pending
+			}
+		}
+	}
+}
+
+// probeOnce health checks the NAT Instances of the last reconciliation in parallel,
+// it returns true if any changed health since it was last checked
+func (c *RouteController) probeOnce() bool {
+	c.mu.Lock()
+	targets := make([]probeTarget, len(c.probed))
+	copy(targets, c.probed)
+	c.mu.Unlock()
+
+	healthy := make([]bool, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			healthy[i] = c.healthCheck(addr, c.config.timeout) == nil
+		}(i, t.addr)
+	}
+	wg.Wait()
+
+	changed := false
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t := range targets {
+		if healthy[i] == t.healthy {
+			continue
+		}
+		if healthy[i] {
+			c.log.Infof("Instance %q (%v) recovered", t.id, t.addr)
+		} else {
+			c.log.Warnf("Instance %q (%v) failed its health check", t.id, t.addr)
+		}
+		changed = true
+		// report a transition once, unless a reconciliation replaced the targets meanwhile
+		for j := range c.probed {
+			if c.probed[j].id == t.id && c.probed[j].healthy == t.healthy {
+				c.probed[j].healthy = healthy[i]
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+	"github.com/so0k/aws-nat-router/pkg/status"
+)
+
+// maxBackoff caps the interval between reconciliations while AWS throttles the API calls,
+// leases and the watchdog may cap it further
+const maxBackoff = 5 * time.Minute
+
+// schedule returns the delay before the next reconciliation of a target: the interval, shortened while routes are
+// degraded and doubled for every consecutive throttled reconciliation, with jitter so nodes do not synchronize
+type schedule struct {
+	interval         time.Duration
+	degradedInterval time.Duration
+	jitter           float64
+	backoff          time.Duration
+	maxBackoff       time.Duration
+	rand             *rand.Rand
+}
+
+// newSchedule returns the schedule of target t
+func newSchedule(t *targetConfig) *schedule {
+	interval := t.reconcileInterval()
+	// a leader backing off should still renew its lease and heartbeat in time
+	max := maxBackoff
+	if t.election == electionDynamoDB || t.election == electionTags {
+		if ttl := t.leaseTTL / 2; ttl < max {
+			max = ttl
+		}
+	}
+	if t.election != electionNone && t.watchdogIntervals > 1 {
+		if watchdog := time.Duration(t.watchdogIntervals-1) * interval; watchdog < max {
+			max = watchdog
+		}
+	}
+	if max < interval {
+		max = interval
+	}
+	return &schedule{
+		interval:         interval,
+		degradedInterval: t.degradedInterval,
+		jitter:           t.jitter,
+		maxBackoff:       max,
+		rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the delay before the reconciliation following cycle
+func (s *schedule) next(cycle *status.Cycle) time.Duration {
+	base := s.interval
+	switch {
+	case cycle != nil && cycle.Throttled:
+		if s.backoff < s.interval {
+			s.backoff = s.interval
+		}
+		s.backoff *= 2
+		if s.backoff > s.maxBackoff {
+			s.backoff = s.maxBackoff
+		}
+		if s.backoff > base {
+			base = s.backoff
+		}
+	case cycle != nil && cycle.Degraded && s.degradedInterval > 0 && s.degradedInterval < base:
+		s.backoff = 0
+		base = s.degradedInterval
+	default:
+		s.backoff = 0
+	}
+	return base + time.Duration(s.jitter*(2*s.rand.Float64()-1)*float64(base))
+}
+
+// throttled returns true if err was caused by AWS throttling an API call
+func throttled(err error) bool {
+	return err != nil && request.IsErrorThrottle(errors.Cause(err))
+}
@@ -0,0 +1,187 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// batch sizes for the describe calls taking a list of names or ids
+const (
+	asgNamesBatch    = 50
+	instanceIdsBatch = 200
+)
+
+// AsgFinder implements Finder interface for AWS, finding Nat Instances through their Auto Scaling groups
+// instead of tags. Routing Tables are still found through tags.
+type AsgFinder struct {
+	*AwsFinder
+	autoscaling autoscalingiface.AutoScalingAPI
+	// groups holds the names of the Auto Scaling groups, if empty groups are found by groupTag
+	groups        []string
+	groupTagKey   string
+	groupTagValue string
+}
+
+// NewAsgFinderFromSession returns AsgFinder from session
+func NewAsgFinderFromSession(session *session.Session, tags Tags, groups []string, groupTagKey, groupTagValue string) (Finder, error) {
+	return NewAsgFinder(ec2.New(session), autoscaling.New(session), tags, groups, groupTagKey, groupTagValue)
+}
+
+// NewAsgFinder returns AsgFinder for ec2 and autoscaling svc, finding Nat Instances in the named Auto Scaling groups
+// or, if no names are given, in the Auto Scaling groups tagged groupTagKey=groupTagValue
+func NewAsgFinder(ec2svc ec2iface.EC2API, asgsvc autoscalingiface.AutoScalingAPI, tags Tags, groups []string, groupTagKey, groupTagValue string) (Finder, error) {
+	if len(groups) == 0 && groupTagKey == "" {
+		return nil, errors.New("Auto Scaling group names or tag required")
+	}
+	f, err := NewAwsFinder(ec2svc, tags)
+	if err != nil {
+		return nil, err
+	}
+	return &AsgFinder{
+		AwsFinder:     f.(*AwsFinder),
+		autoscaling:   asgsvc,
+		groups:        groups,
+		groupTagKey:   groupTagKey,
+		groupTagValue: groupTagValue,
+	}, nil
+}
+
+// FindNatInstances returns a list of Nat Instances in the Auto Scaling groups, clusterId is ignored
+func (r *AsgFinder) FindNatInstances(ctx context.Context, clusterId, vpcId string) ([]*NatInstance, error) {
+	r.report = &Report{}
+	groups, err := r.findGroupNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// index Auto Scaling group membership by InstanceId
+	members := make(map[string]*autoscaling.Instance)
+	memberOf := make(map[string]string)
+	for start := 0; start < len(groups); start += asgNamesBatch {
+		end := start + asgNamesBatch
+		if end > len(groups) {
+			end = len(groups)
+		}
+		input := &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: aws.StringSlice(groups[start:end]),
+		}
+		err := r.autoscaling.DescribeAutoScalingGroupsPagesWithContext(ctx, input,
+			func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				for _, g := range page.AutoScalingGroups {
+					for _, i := range g.Instances {
+						if i.InstanceId == nil {
+							continue
+						}
+						members[*i.InstanceId] = i
+						memberOf[*i.InstanceId] = aws.StringValue(g.AutoScalingGroupName)
+					}
+				}
+				// to stop iterating, return false
+				return true
+			})
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to find Auto Scaling groups")
+		}
+	}
+	if len(members) == 0 {
+		log.Debugf("No Instances in Auto Scaling groups %v", groups)
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+
+	var natInstances []*NatInstance
+	zones := r.zoneIndex(ctx, vpcId)
+	log.Debugf("Finding Instances of Auto Scaling groups %v with 'vpc-id=%v'", groups, vpcId)
+	for start := 0; start < len(ids); start += instanceIdsBatch {
+		end := start + instanceIdsBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		// an instance-id filter skips instances terminated since, InstanceIds would fail the whole batch
+		input := &ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("instance-id"),
+					Values: aws.StringSlice(ids[start:end]),
+				},
+				{
+					Name: aws.String("vpc-id"),
+					Values: []*string{
+						aws.String(vpcId),
+					},
+				},
+			},
+		}
+		err := r.ec2.DescribeInstancesPagesWithContext(ctx, input,
+			func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				for _, res := range page.Reservations {
+					for _, i := range res.Instances {
+						ni := r.newNatInstance(i, zones)
+						if ni == nil {
+							continue
+						}
+						ni.AutoScalingGroup = memberOf[ni.Id]
+						ni.LifecycleState = aws.StringValue(members[ni.Id].LifecycleState)
+						log.Debugf("Discovered %v (%v) in %v, %v in %v", ni.Id, ni.PrivateIP, ni.ZoneKey(), ni.LifecycleState, ni.AutoScalingGroup)
+						natInstances = append(natInstances, ni)
+					}
+				}
+				// to stop iterating, return false
+				return true
+			})
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to Find Nat Instances")
+		}
+	}
+	return natInstances, nil
+}
+
+// findGroupNames returns the configured Auto Scaling group names or the names of the tagged Auto Scaling groups
+func (r *AsgFinder) findGroupNames(ctx context.Context) ([]string, error) {
+	if len(r.groups) > 0 {
+		return r.groups, nil
+	}
+
+	filters := []*autoscaling.Filter{
+		{
+			Name:   aws.String("key"),
+			Values: []*string{aws.String(r.groupTagKey)},
+		},
+	}
+	if r.groupTagValue != "" {
+		filters = append(filters, &autoscaling.Filter{
+			Name:   aws.String("value"),
+			Values: []*string{aws.String(r.groupTagValue)},
+		})
+	}
+
+	var groups []string
+	log.Debugf("Finding Auto Scaling groups with tag %v", fmt.Sprintf("%v=%v", r.groupTagKey, r.groupTagValue))
+	err := r.autoscaling.DescribeTagsPagesWithContext(ctx, &autoscaling.DescribeTagsInput{Filters: filters},
+		func(page *autoscaling.DescribeTagsOutput, lastPage bool) bool {
+			for _, t := range page.Tags {
+				if aws.StringValue(t.ResourceType) == "auto-scaling-group" && t.ResourceId != nil {
+					groups = append(groups, *t.ResourceId)
+				}
+			}
+			// to stop iterating, return false
+			return true
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find Auto Scaling groups")
+	}
+	return groups, nil
+}
@@ -0,0 +1,220 @@
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Inventory is the format of the inventory file read by FileFinder
+type Inventory struct {
+	NatInstances  []InventoryNatInstance  `json:"natInstances"`
+	RoutingTables []InventoryRoutingTable `json:"routingTables"`
+}
+
+// InventoryNatInstance describes a Nat Instance in the inventory file,
+// a blank ClusterId or VpcId matches any cluster or vpc and a blank State is running
+type InventoryNatInstance struct {
+	Id              string    `json:"id"`
+	ClusterId       string    `json:"clusterId"`
+	VpcId           string    `json:"vpcId"`
+	State           string    `json:"state"`
+	PrivateIP       string    `json:"privateIp"`
+	PublicIP        string    `json:"publicIp"`
+	Zone            string    `json:"zone"`
+	ZoneId          string    `json:"zoneId"`
+	SourceDestCheck bool      `json:"sourceDestCheck"`
+	LaunchTime      time.Time `json:"launchTime"`
+	Spot            bool      `json:"spot"`
+	Priority        int       `json:"priority"`
+}
+
+// InventoryRoutingTable describes a Routing Table in the inventory file,
+// a blank ClusterId or VpcId matches any cluster or vpc
+type InventoryRoutingTable struct {
+	Id                  string `json:"id"`
+	ClusterId           string `json:"clusterId"`
+	VpcId               string `json:"vpcId"`
+	Zone                string `json:"zone"`
+	ZoneId              string `json:"zoneId"`
+	EgressNatInstanceId string `json:"egressNatInstanceId"`
+}
+
+// FileFinder implements Finder interface for an inventory file, for labs and offline testing.
+// The file is read again when it changes.
+//
+// FileFinder also implements router.Router, routes and source/destination checks are applied
+// to the inventory in memory until the file changes.
+type FileFinder struct {
+	path string
+
+	mu        sync.Mutex
+	modTime   time.Time
+	size      int64
+	inventory *Inventory
+	report    *Report
+}
+
+// NewFileFinder returns FileFinder for the inventory file at path
+func NewFileFinder(path string) (*FileFinder, error) {
+	f := &FileFinder{
+		path: path,
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load reads the inventory file if it changed since it was last read
+func (f *FileFinder) load() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read inventory")
+	}
+	if f.inventory != nil && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read inventory")
+	}
+	inv := &Inventory{}
+	if err := json.Unmarshal(b, inv); err != nil {
+		return errors.Wrap(err, "Unable to parse inventory")
+	}
+	log.Infof("Loaded inventory %v: %v Nat Instances, %v Routing Tables", f.path, len(inv.NatInstances), len(inv.RoutingTables))
+	f.inventory, f.modTime, f.size = inv, fi.ModTime(), fi.Size()
+	return nil
+}
+
+// FindNatInstances returns a list of Nat Instances in the inventory
+func (f *FileFinder) FindNatInstances(ctx context.Context, clusterId, vpcId string) ([]*NatInstance, error) {
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.report = &Report{}
+
+	var natInstances []*NatInstance
+	for _, i := range f.inventory.NatInstances {
+		if !matches(i.ClusterId, clusterId) || !matches(i.VpcId, vpcId) {
+			continue
+		}
+		if i.Id == "" {
+			f.report.skip("unknown instance", "missing id")
+			continue
+		}
+		if i.LaunchTime.IsZero() {
+			f.report.skip(i.Id, "missing launchTime")
+			continue
+		}
+		state := i.State
+		if state == "" {
+			state = ec2.InstanceStateNameRunning
+		} else if !validState(state) {
+			f.report.flag(i.Id, "unknown state %q", state)
+		}
+		ni := &NatInstance{
+			Id:              i.Id,
+			State:           state,
+			PrivateIP:       i.PrivateIP,
+			PublicIP:        i.PublicIP,
+			Zone:            i.Zone,
+			ZoneId:          i.ZoneId,
+			SourceDestCheck: i.SourceDestCheck,
+			LaunchTime:      i.LaunchTime,
+			Spot:            i.Spot,
+			Priority:        i.Priority,
+		}
+		log.Debugf("Discovered %v (%v) in %v", ni.Id, ni.PrivateIP, ni.ZoneKey())
+		natInstances = append(natInstances, ni)
+	}
+	return natInstances, nil
+}
+
+// FindRoutingTables returns a list of Routing Tables in the inventory
+func (f *FileFinder) FindRoutingTables(ctx context.Context, clusterId, vpcId string) ([]*RoutingTable, error) {
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.report == nil {
+		f.report = &Report{}
+	}
+
+	var routingTables []*RoutingTable
+	for _, t := range f.inventory.RoutingTables {
+		if !matches(t.ClusterId, clusterId) || !matches(t.VpcId, vpcId) {
+			continue
+		}
+		if t.Id == "" {
+			f.report.skip("unknown route table", "missing id")
+			continue
+		}
+		rt := &RoutingTable{
+			Id:                  t.Id,
+			Zone:                t.Zone,
+			ZoneId:              t.ZoneId,
+			EgressNatInstanceId: t.EgressNatInstanceId,
+		}
+		log.Debugf("Discovered %v (%v)", rt.Id, rt.ZoneKey())
+		routingTables = append(routingTables, rt)
+	}
+	return routingTables, nil
+}
+
+// Report returns the validation report for the resources found since FindNatInstances was last called
+func (f *FileFinder) Report() *Report {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.report == nil {
+		return &Report{}
+	}
+	return f.report
+}
+
+// UpsertNatRoute routes the Routing Table through the Nat Instance in the inventory
+func (f *FileFinder) UpsertNatRoute(ctx context.Context, destinationCidrBlock string, ni *NatInstance, rt *RoutingTable) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.inventory.RoutingTables {
+		if f.inventory.RoutingTables[i].Id == rt.Id {
+			log.Debugf("Routing %v (%v) via %v (%v)", rt.Id, rt.ZoneKey(), ni.Id, ni.ZoneKey())
+			f.inventory.RoutingTables[i].EgressNatInstanceId = ni.Id
+			return nil
+		}
+	}
+	return errors.Errorf("Unable to update route: %v not in inventory", rt.Id)
+}
+
+// PreventSourceDestCheck disables source/destination checking of the Nat Instance in the inventory
+func (f *FileFinder) PreventSourceDestCheck(ctx context.Context, ni *NatInstance) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.inventory.NatInstances {
+		if f.inventory.NatInstances[i].Id == ni.Id {
+			f.inventory.NatInstances[i].SourceDestCheck = false
+			return nil
+		}
+	}
+	return errors.Errorf("Unable to PreventSourceDestCheck: %v not in inventory", ni.Id)
+}
+
+// matches returns true if the inventory value is blank or equal to the wanted value
+func matches(value, want string) bool {
+	return value == "" || value == want
+}
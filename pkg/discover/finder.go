@@ -1,35 +1,109 @@
 package discover
 
 import (
+	"context"
+
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/pkg/errors"
 )
 
-const clusterTag = "aws-nat-router/id"
-const zoneTag = "aws-nat-router/zone"
+// Tags holds the keys of every tag the router reads or writes
+type Tags struct {
+	// ClusterId tags Nat Instances and Routing Tables managed by the router
+	ClusterId string `json:"clusterId"`
+	// Zone optionally overrides the discovered zone
+	Zone string `json:"zone"`
+	// LeaseHolder, LeaseTerm and LeaseExpires hold the leader lease of the tags election
+	LeaseHolder  string `json:"leaseHolder"`
+	LeaseTerm    string `json:"leaseTerm"`
+	LeaseExpires string `json:"leaseExpires"`
+	// Heartbeat holds the time of the last successful reconciliation of the leader
+	Heartbeat string `json:"heartbeat"`
+	// Fence holds the latest leadership term which updated a Routing Table
+	Fence string `json:"fence"`
+	// Priority ranks Nat Instances for leadership when ordered by priority
+	Priority string `json:"priority"`
+	// Drain marks a Nat Instance whose routes should be moved, e.g. while its router shuts down
+	Drain string `json:"drain"`
+}
+
+// DefaultTags holds the tag keys used unless configured otherwise
+var DefaultTags = Tags{
+	ClusterId:    "aws-nat-router/id",
+	Zone:         "aws-nat-router/zone",
+	LeaseHolder:  "aws-nat-router/lease-holder",
+	LeaseTerm:    "aws-nat-router/lease-term",
+	LeaseExpires: "aws-nat-router/lease-expires",
+	Heartbeat:    "aws-nat-router/heartbeat",
+	Fence:        "aws-nat-router/fence",
+	Priority:     "aws-nat-router/priority",
+	Drain:        "aws-nat-router/drain",
+}
+
+// Validate returns an error if a tag key is blank or used for more than one tag
+func (t Tags) Validate() error {
+	seen := make(map[string]bool)
+	for _, k := range []string{t.ClusterId, t.Zone, t.LeaseHolder, t.LeaseTerm, t.LeaseExpires, t.Heartbeat, t.Fence, t.Priority, t.Drain} {
+		if k == "" {
+			return errors.New("tag keys can not be blank")
+		}
+		if seen[k] {
+			return errors.Errorf("tag key %q is used for more than one tag", k)
+		}
+		seen[k] = true
+	}
+	return nil
+}
 
 // Finder interface to find cloud resources
 type Finder interface {
 	// FindNatInstances returns a list of Nat Instances tagged for router
-	FindNatInstances(clusterId, vpcId string) ([]*NatInstance, error)
+	FindNatInstances(ctx context.Context, clusterId, vpcId string) ([]*NatInstance, error)
 	// FindRoutingTables returns a list of Routing Tables tagged for router
-	FindRoutingTables(clusterId, vpcId string) ([]*RoutingTable, error)
+	FindRoutingTables(ctx context.Context, clusterId, vpcId string) ([]*RoutingTable, error)
+	// Report returns the validation report for the resources found since FindNatInstances was last called
+	Report() *Report
 }
 
 // AwsFinder implements Finder interface for AWS
 type AwsFinder struct {
-	ec2 ec2iface.EC2API
+	ec2    ec2iface.EC2API
+	tags   Tags
+	zones  *zoneIndex
+	report *Report
 }
 
 // NewAwsFinderFromSession returns Awsfinder from session
-func NewAwsFinderFromSession(session *session.Session) (Finder, error) {
-	return NewAwsFinder(ec2.New(session))
+func NewAwsFinderFromSession(session *session.Session, tags Tags) (Finder, error) {
+	return NewAwsFinder(ec2.New(session), tags)
 }
 
-// NewAwsFinder returns Awsfinder for ec2 svc
-func NewAwsFinder(svc ec2iface.EC2API) (Finder, error) {
+// NewAwsFinder returns Awsfinder for ec2 svc using tags
+func NewAwsFinder(svc ec2iface.EC2API, tags Tags) (Finder, error) {
+	if err := tags.Validate(); err != nil {
+		return nil, err
+	}
 	return &AwsFinder{
-		ec2: svc,
+		ec2:    svc,
+		tags:   tags,
+		zones:  newZoneIndex(),
+		report: &Report{},
 	}, nil
 }
+
+// Report returns the validation report for the resources found since FindNatInstances was last called
+func (r *AwsFinder) Report() *Report {
+	return r.report
+}
+
+// tagValue returns the value of the tag with key if it is set
+func tagValue(tags []*ec2.Tag, key string) (string, bool) {
+	for _, t := range tags {
+		if t.Key != nil && t.Value != nil && *t.Key == key {
+			return *t.Value, true
+		}
+	}
+	return "", false
+}
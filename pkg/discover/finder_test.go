@@ -0,0 +1,365 @@
+package discover_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/fake"
+)
+
+func TestAwsFinder(t *testing.T) {
+	f := fake.NewEC2()
+	f.PageSize = 1
+	f.AddZone("ap-southeast-1a", "apse1-az2")
+	f.AddZone("ap-southeast-1b", "apse1-az1")
+	f.AddSubnet("subnet-a", "vpc-1", "ap-southeast-1a")
+	f.AddSubnet("subnet-b", "vpc-1", "ap-southeast-1b")
+	tags := map[string]string{discover.DefaultTags.ClusterId: "squid"}
+	f.AddInstance(fake.Instance{Id: "i-a", VpcId: "vpc-1", Zone: "ap-southeast-1a", PrivateIP: "10.0.1.10", LaunchTime: time.Now(), Lifecycle: "spot", Tags: map[string]string{
+		discover.DefaultTags.ClusterId: "squid",
+		discover.DefaultTags.Priority:  "5",
+	}})
+	f.AddInstance(fake.Instance{Id: "i-other", VpcId: "vpc-2", Zone: "ap-southeast-1a", PrivateIP: "10.1.1.10", LaunchTime: time.Now(), Tags: tags})
+	f.AddInstance(fake.Instance{Id: "i-untagged", VpcId: "vpc-1", Zone: "ap-southeast-1a", PrivateIP: "10.0.1.11", LaunchTime: time.Now()})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-a", VpcId: "vpc-1", Subnets: []string{"subnet-a"}, Egress: "i-a", Tags: tags})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-ab", VpcId: "vpc-1", Subnets: []string{"subnet-a", "subnet-b"}, Tags: tags})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-tagged", VpcId: "vpc-1", Tags: map[string]string{
+		discover.DefaultTags.ClusterId: "squid",
+		discover.DefaultTags.Zone:      "apse1-az1",
+	}})
+
+	finder, _ := discover.NewAwsFinder(f, discover.DefaultTags)
+	nis, err := finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nis) != 1 || nis[0].Id != "i-a" || nis[0].ZoneKey() != "apse1-az2" || !nis[0].SourceDestCheck {
+		t.Errorf("got %v, want i-a in apse1-az2", nis)
+	}
+	if len(nis) == 1 && (!nis[0].Spot || nis[0].Priority != 5) {
+		t.Errorf("i-a is spot %v with priority %v, want spot with priority 5", nis[0].Spot, nis[0].Priority)
+	}
+
+	rts, err := finder.FindRoutingTables(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"rtb-a":      "apse1-az2",
+		"rtb-ab":     "", // subnets in multiple zones
+		"rtb-tagged": "apse1-az1",
+	}
+	if len(rts) != len(want) {
+		t.Errorf("found %v routing tables, want %v", len(rts), len(want))
+	}
+	for _, rt := range rts {
+		if rt.ZoneKey() != want[rt.Id] {
+			t.Errorf("%v in zone %q, want %q", rt.Id, rt.ZoneKey(), want[rt.Id])
+		}
+	}
+	if rts[0].EgressNatInstanceId != "i-a" {
+		t.Errorf("%v routes through %q, want i-a", rts[0].Id, rts[0].EgressNatInstanceId)
+	}
+	// rtb-ab is multi-zone and rtb-tagged is unassociated, only rtb-ab is flagged
+	if issues := finder.Report().Issues; len(issues) != 1 || issues[0].ResourceId != "rtb-ab" {
+		t.Errorf("report %v, want rtb-ab flagged", issues)
+	}
+}
+
+func TestTagsValidate(t *testing.T) {
+	if err := discover.DefaultTags.Validate(); err != nil {
+		t.Errorf("DefaultTags: %v", err)
+	}
+	blank := discover.DefaultTags
+	blank.Fence = ""
+	duplicate := discover.DefaultTags
+	duplicate.Drain = duplicate.ClusterId
+	for name, tags := range map[string]discover.Tags{"blank": blank, "duplicate": duplicate} {
+		if err := tags.Validate(); err == nil {
+			t.Errorf("%v tag key accepted", name)
+		}
+		if _, err := discover.NewAwsFinder(fake.NewEC2(), tags); err == nil {
+			t.Errorf("AwsFinder created with %v tag key", name)
+		}
+	}
+}
+
+func TestAwsFinderZonesFallback(t *testing.T) {
+	f := fake.NewEC2()
+	f.AddZone("ap-southeast-1a", "apse1-az2")
+	f.AddSubnet("subnet-a", "vpc-1", "ap-southeast-1a")
+	tags := map[string]string{discover.DefaultTags.ClusterId: "squid"}
+	f.AddInstance(fake.Instance{Id: "i-a", VpcId: "vpc-1", Zone: "ap-southeast-1a", PrivateIP: "10.0.1.10", LaunchTime: time.Now(), Tags: tags})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-a", VpcId: "vpc-1", Subnets: []string{"subnet-a"}, Tags: tags})
+
+	// AZ IDs are learned from the subnets while AvailabilityZones can not be found
+	f.Fail("DescribeAvailabilityZones", 1, errors.New("UnauthorizedOperation"))
+	finder, _ := discover.NewAwsFinder(f, discover.DefaultTags)
+	nis, err := finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nis) != 1 || nis[0].ZoneKey() != "apse1-az2" {
+		t.Errorf("got %v, want i-a in apse1-az2", nis)
+	}
+
+	// the failure is not cached
+	rts, err := finder.FindRoutingTables(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rts) != 1 || rts[0].ZoneKey() != "apse1-az2" {
+		t.Errorf("got %v, want rtb-a in apse1-az2", rts)
+	}
+	if got := f.Calls("DescribeAvailabilityZones"); got != 2 {
+		t.Errorf("DescribeAvailabilityZones called %v times, want 2", got)
+	}
+	finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if got := f.Calls("DescribeAvailabilityZones"); got != 2 {
+		t.Errorf("DescribeAvailabilityZones called %v times, want 2", got)
+	}
+}
+
+func TestAwsFinderMalformedInstances(t *testing.T) {
+	f := fake.NewEC2()
+	f.AddZone("ap-southeast-1a", "apse1-az2")
+	tags := []*ec2.Tag{{Key: aws.String(discover.DefaultTags.ClusterId), Value: aws.String("squid")}}
+	// i-bare only has the attributes used by the filters
+	f.AddRawInstance(&ec2.Instance{
+		InstanceId: aws.String("i-bare"),
+		VpcId:      aws.String("vpc-1"),
+		LaunchTime: aws.Time(time.Now()),
+		Tags:       tags,
+	})
+	f.AddRawInstance(&ec2.Instance{
+		InstanceId:       aws.String("i-unlaunched"),
+		VpcId:            aws.String("vpc-1"),
+		PrivateIpAddress: aws.String("10.0.1.11"),
+		Tags:             tags,
+	})
+	f.AddRawInstance(&ec2.Instance{
+		VpcId: aws.String("vpc-1"),
+		Tags:  tags,
+	})
+
+	finder, _ := discover.NewAwsFinder(f, discover.DefaultTags)
+	nis, err := finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nis) != 1 || nis[0].Id != "i-bare" {
+		t.Fatalf("got %v, want i-bare", nis)
+	}
+	// a missing SourceDestCheck is disabled to be safe
+	if ni := nis[0]; ni.State != "" || !ni.SourceDestCheck || ni.PrivateIP != "" || ni.PublicIP != "" || ni.Zone != "" {
+		t.Errorf("i-bare discovered as %+v", ni)
+	}
+
+	want := map[discover.Issue]bool{
+		{ResourceId: "i-bare", Reason: "missing State"}:                               true,
+		{ResourceId: "i-bare", Reason: "missing SourceDestCheck"}:                     true,
+		{ResourceId: "i-bare", Reason: "missing PrivateIpAddress"}:                    true,
+		{ResourceId: "i-bare", Reason: "unknown zone"}:                                true,
+		{ResourceId: "i-unlaunched", Reason: "missing LaunchTime", Skipped: true}:     true,
+		{ResourceId: "unknown instance", Reason: "missing InstanceId", Skipped: true}: true,
+	}
+	issues := finder.Report().Issues
+	for _, issue := range issues {
+		if !want[issue] {
+			t.Errorf("unexpected issue %+v", issue)
+		}
+	}
+	if len(issues) != len(want) {
+		t.Errorf("report %+v, want %v issues", issues, len(want))
+	}
+}
+
+func TestAsgFinder(t *testing.T) {
+	f := fake.NewEC2()
+	f.AddZone("ap-southeast-1a", "apse1-az2")
+	states := map[string]string{
+		"i-pending":     "Pending",
+		"i-inservice":   "InService",
+		"i-terminating": "Terminating",
+		"i-detaching":   "Detaching",
+		"i-entering":    "EnteringStandby",
+		"i-standby":     "Standby",
+	}
+	for id := range states {
+		f.AddInstance(fake.Instance{Id: id, VpcId: "vpc-1", Zone: "ap-southeast-1a", PrivateIP: "10.0.1.10", LaunchTime: time.Now()})
+	}
+	f.AddInstance(fake.Instance{Id: "i-other", VpcId: "vpc-1", Zone: "ap-southeast-1a", PrivateIP: "10.0.1.11", LaunchTime: time.Now()})
+	f.AddInstance(fake.Instance{Id: "i-vpc-2", VpcId: "vpc-2", Zone: "ap-southeast-1a", PrivateIP: "10.1.1.10", LaunchTime: time.Now()})
+	asg := fake.NewAutoScaling()
+	asg.PageSize = 1
+	asg.AddGroup("nat", map[string]string{"nat-router": "squid"}, states)
+	asg.AddGroup("other", map[string]string{"nat-router": "other"}, map[string]string{"i-other": "InService", "i-vpc-2": "InService"})
+	asg.AddGroup("untagged", nil, map[string]string{"i-untagged": "InService"})
+
+	for _, tt := range []struct {
+		name     string
+		groups   []string
+		key      string
+		value    string
+		want     int
+		describe int
+	}{
+		{name: "names", groups: []string{"nat"}, want: len(states)},
+		{name: "tag", key: "nat-router", value: "squid", want: len(states), describe: 1},
+		// instances outside the vpc are left out
+		{name: "tag key", key: "nat-router", want: len(states) + 1, describe: 1},
+		{name: "unknown tag", key: "nat", describe: 1},
+	} {
+		describe := asg.Calls("DescribeTags")
+		finder, err := discover.NewAsgFinder(f, asg, discover.DefaultTags, tt.groups, tt.key, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nis, err := finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if len(nis) != tt.want {
+			t.Errorf("%v: found %v, want %v NAT Instances", tt.name, nis, tt.want)
+		}
+		if got := asg.Calls("DescribeTags") - describe; got != tt.describe {
+			t.Errorf("%v: DescribeTags called %v times, want %v", tt.name, got, tt.describe)
+		}
+	}
+
+	finder, _ := discover.NewAsgFinder(f, asg, discover.DefaultTags, []string{"nat"}, "", "")
+	nis, err := finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ni := range nis {
+		if ni.AutoScalingGroup != "nat" || ni.LifecycleState != states[ni.Id] {
+			t.Errorf("%v is %v in %q, want %v in nat", ni.Id, ni.LifecycleState, ni.AutoScalingGroup, states[ni.Id])
+		}
+	}
+	for _, tt := range []struct {
+		state     string
+		inService bool
+		draining  bool
+	}{
+		// a Pending instance may already forward traffic
+		{"Pending", true, false},
+		{"InService", true, false},
+		{"Terminating", false, true},
+		{"Detaching", false, true},
+		{"EnteringStandby", false, true},
+		// routes were moved while it entered Standby
+		{"Standby", false, false},
+	} {
+		for _, ni := range nis {
+			if ni.LifecycleState != tt.state {
+				continue
+			}
+			if ni.InService() != tt.inService || ni.Draining() != tt.draining {
+				t.Errorf("%v is in service %v and draining %v, want %v and %v", tt.state, ni.InService(), ni.Draining(), tt.inService, tt.draining)
+			}
+		}
+	}
+}
+
+func TestNatInstanceInService(t *testing.T) {
+	for state, want := range map[string]bool{
+		"":             true,
+		"InService":    true,
+		"Pending:Wait": true,
+		"Standby":      false,
+		"Terminating":  false,
+	} {
+		ni := &discover.NatInstance{Id: "i-a", LifecycleState: state}
+		if got := ni.InService(); got != want {
+			t.Errorf("InService in %q = %v, want %v", state, got, want)
+		}
+	}
+}
+
+func TestFileFinderStates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inventory.json")
+	inventory := `{"natInstances": [
+		{"id": "i-a", "launchTime": "2018-09-01T00:00:00Z"},
+		{"id": "i-b", "state": "runing", "launchTime": "2018-09-02T00:00:00Z"}
+	]}`
+	if err := ioutil.WriteFile(path, []byte(inventory), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	finder, err := discover.NewFileFinder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nis, err := finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nis) != 2 || nis[0].State != "running" {
+		t.Errorf("got %v, want i-a running", nis)
+	}
+	if issues := finder.Report().Issues; len(issues) != 1 || issues[0].ResourceId != "i-b" {
+		t.Errorf("report %v, want i-b flagged", issues)
+	}
+}
+
+func TestFileFinderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inventory.json")
+	write := func(inventory string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(inventory), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// the modification time may not change within the resolution of the file system
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	written := time.Now().Add(-time.Hour)
+	write(`{"natInstances": [{"id": "i-a", "state": "running", "launchTime": "2018-09-01T00:00:00Z"}]}`, written)
+
+	finder, err := discover.NewFileFinder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nis, err := finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nis) != 1 || len(finder.Report().Issues) != 0 {
+		t.Fatalf("got %v with issues %v, want i-a", nis, finder.Report().Issues)
+	}
+
+	write(`{"natInstances": [
+		{"id": "i-a", "state": "stopping", "launchTime": "2018-09-01T00:00:00Z"},
+		{"id": "i-b", "state": "hibernated", "launchTime": "2018-09-02T00:00:00Z"}
+	]}`, written.Add(time.Minute))
+	nis, err = finder.FindNatInstances(context.Background(), "squid", "vpc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nis) != 2 || nis[0].State != "stopping" || nis[1].State != "hibernated" {
+		t.Errorf("got %v, want the rewritten inventory", nis)
+	}
+	issues := finder.Report().Issues
+	if len(issues) != 1 || issues[0].ResourceId != "i-b" || issues[0].Reason != `unknown state "hibernated"` {
+		t.Errorf("report %v, want i-b flagged for its unknown state", issues)
+	}
+}
@@ -1,6 +1,9 @@
 package discover
 
 import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/pkg/errors"
@@ -9,7 +12,27 @@ import (
 // Identifier interface helps identify instance Id
 type Identifier interface {
 	// GetIdentity returns the identity of
-	GetIdentity() (string, error)
+	GetIdentity(ctx context.Context) (string, error)
+}
+
+// StaticIdentifier returns a configured identity, e.g. to run outside EC2
+type StaticIdentifier struct {
+	id string
+}
+
+// NewStaticIdentifier returns Identifier for id
+func NewStaticIdentifier(id string) (Identifier, error) {
+	if id == "" {
+		return nil, errors.New("identity can not be blank")
+	}
+	return &StaticIdentifier{
+		id: id,
+	}, nil
+}
+
+// GetIdentity returns the configured identity
+func (i *StaticIdentifier) GetIdentity(ctx context.Context) (string, error) {
+	return i.id, nil
 }
 
 // ref - https://github.com/stylight/etcd-bootstrap
@@ -20,9 +43,15 @@ type EC2MetadataIface interface {
 	Available() bool
 }
 
-// NewAwsIdentifierFromSession returns Identifier for ec2Metadata service using a session object
-func NewAwsIdentifierFromSession(s *session.Session) (Identifier, error) {
-	return NewAwsIdentifier(ec2metadata.New(s))
+// ec2MetadataWithContext is implemented by metadata clients whose requests can be cancelled
+type ec2MetadataWithContext interface {
+	GetInstanceIdentityDocumentWithContext(ctx context.Context) (ec2metadata.EC2InstanceIdentityDocument, error)
+}
+
+// NewAwsIdentifierFromSession returns Identifier for ec2Metadata service using a session object,
+// cfgs may override the metadata endpoint
+func NewAwsIdentifierFromSession(s *session.Session, cfgs ...*aws.Config) (Identifier, error) {
+	return NewAwsIdentifier(ec2metadata.New(s, cfgs...))
 }
 
 // NewAwsIdentifier returns Identifier for ec2Metadata service
@@ -38,12 +67,15 @@ type AwsIdentifier struct {
 }
 
 // GetIdentity gets the curernt InstanceID
-func (i AwsIdentifier) GetIdentity() (string, error) {
-	if !i.service.Available() {
-		return "", errors.New("Metadata is not available")
+func (i AwsIdentifier) GetIdentity(ctx context.Context) (string, error) {
+	// errors of the identity document are more telling than Available
+	var d ec2metadata.EC2InstanceIdentityDocument
+	var e error
+	if svc, ok := i.service.(ec2MetadataWithContext); ok {
+		d, e = svc.GetInstanceIdentityDocumentWithContext(ctx)
+	} else {
+		d, e = i.service.GetInstanceIdentityDocument()
 	}
-
-	d, e := i.service.GetInstanceIdentityDocument()
 	if e != nil {
 		return "", errors.Wrap(e, "Unable to retrieve Instance Identity")
 	}
@@ -0,0 +1,35 @@
+package discover
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// hopLimitHint explains the most likely cause of a token request failing
+const hopLimitHint = "the instance metadata service may be disabled or the response dropped by the hop limit, e.g. when running in a container, " +
+	"raise it with `aws ec2 modify-instance-metadata-options --http-put-response-hop-limit 2`"
+
+// requireTokenHandler rejects metadata requests the ec2metadata client would send without an IMDSv2 token.
+// The client falls back to IMDSv1 on its own once a token request failed.
+var requireTokenHandler = request.NamedHandler{
+	Name: "discover.RequireTokenHandler",
+	Fn: func(r *request.Request) {
+		if r.Error != nil || r.Operation.Name == "GetToken" || r.HTTPRequest.Header.Get("X-Aws-Ec2-Metadata-Token") != "" {
+			return
+		}
+		r.Error = awserr.New("IMDSv2TokenUnavailable", "Unable to retrieve IMDSv2 token, "+hopLimitHint, nil)
+	},
+}
+
+// NewIMDSIdentifier returns Identifier for the ec2metadata client of the session, which uses IMDSv2 session tokens.
+// Requests without a token are rejected unless fallbackV1 is set, cfgs may override the metadata endpoint.
+func NewIMDSIdentifier(s *session.Session, fallbackV1 bool, cfgs ...*aws.Config) (Identifier, error) {
+	svc := ec2metadata.New(s, cfgs...)
+	if !fallbackV1 {
+		svc.Handlers.Sign.PushBackNamed(requireTokenHandler)
+	}
+	return NewAwsIdentifier(svc)
+}
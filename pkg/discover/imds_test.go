@@ -0,0 +1,100 @@
+package discover_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+)
+
+// imds is a fake instance metadata service, requireV2 rejects requests without a valid token
+type imds struct {
+	mu        sync.Mutex
+	requireV2 bool
+	noV2      bool
+	tokens    int
+	valid     string
+}
+
+func (m *imds) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+		if m.noV2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.tokens++
+		m.valid = fmt.Sprintf("token-%v", m.tokens)
+		w.Header().Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+		fmt.Fprint(w, m.valid)
+	case r.Method == http.MethodGet && r.URL.Path == "/latest/dynamic/instance-identity/document":
+		token := r.Header.Get("X-aws-ec2-metadata-token")
+		if (token == "" && m.requireV2) || (token != "" && token != m.valid) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"instanceId": "i-a", "region": "ap-southeast-1"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *imds) tokensRetrieved() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tokens
+}
+
+func newIMDSIdentifier(t *testing.T, url string, fallbackV1 bool) discover.Identifier {
+	s := session.New(aws.NewConfig().WithMaxRetries(0))
+	i, err := discover.NewIMDSIdentifier(s, fallbackV1, aws.NewConfig().WithEndpoint(url+"/latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestIMDSIdentifier(t *testing.T) {
+	m := &imds{requireV2: true}
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	i := newIMDSIdentifier(t, srv.URL, false)
+	for n := 0; n < 2; n++ {
+		id, err := i.GetIdentity(context.Background())
+		if err != nil || id != "i-a" {
+			t.Fatalf("GetIdentity = %q, %v, want i-a", id, err)
+		}
+	}
+	if n := m.tokensRetrieved(); n != 1 {
+		t.Errorf("%v tokens retrieved, want the token cached", n)
+	}
+}
+
+func TestIMDSIdentifierFallbackV1(t *testing.T) {
+	m := &imds{noV2: true}
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	_, err := newIMDSIdentifier(t, srv.URL, false).GetIdentity(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "hop-limit") {
+		t.Errorf("got %v without fallback, want IMDSv2 token error", err)
+	}
+	id, err := newIMDSIdentifier(t, srv.URL, true).GetIdentity(context.Background())
+	if err != nil || id != "i-a" {
+		t.Errorf("GetIdentity with fallback = %q, %v, want i-a", id, err)
+	}
+}
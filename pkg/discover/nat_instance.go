@@ -1,10 +1,13 @@
 package discover
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 
 	"github.com/pkg/errors"
@@ -19,16 +22,84 @@ type NatInstance struct {
 	PrivateIP       string
 	PublicIP        string
 	Zone            string
+	ZoneId          string
 	SourceDestCheck bool
 	LaunchTime      time.Time
+	// Spot is true for Spot Instances, which may be interrupted at short notice
+	Spot bool
+	// Priority ranks the Nat Instance for leadership when ordered by priority, higher leads
+	Priority int
+	// Drain is set when the router on the Nat Instance asked for its routes to be moved, e.g. on shutdown
+	Drain bool
+	// AutoScalingGroup and LifecycleState are only set when discovered through Auto Scaling groups
+	AutoScalingGroup string
+	LifecycleState   string
+}
+
+// InstanceStates lists the EC2 instance states a Nat Instance can be in
+var InstanceStates = []string{
+	ec2.InstanceStateNamePending,
+	ec2.InstanceStateNameRunning,
+	ec2.InstanceStateNameShuttingDown,
+	ec2.InstanceStateNameTerminated,
+	ec2.InstanceStateNameStopping,
+	ec2.InstanceStateNameStopped,
+}
+
+// validState returns true if state is one of InstanceStates
+func validState(state string) bool {
+	for _, s := range InstanceStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Draining returns true if the Nat Instance is stopping, shutting down, leaving its Auto Scaling group
+// or tagged to drain and its routes should be moved
+func (ni *NatInstance) Draining() bool {
+	if ni.Drain {
+		return true
+	}
+	switch ni.LifecycleState {
+	case autoscaling.LifecycleStateTerminating,
+		autoscaling.LifecycleStateTerminatingWait,
+		autoscaling.LifecycleStateTerminatingProceed,
+		autoscaling.LifecycleStateDetaching,
+		autoscaling.LifecycleStateEnteringStandby:
+		return true
+	}
+	return ni.State == ec2.InstanceStateNameStopping || ni.State == ec2.InstanceStateNameShuttingDown
+}
+
+// InService returns true if the Nat Instance is InService or Pending in its Auto Scaling group,
+// or was not discovered through an Auto Scaling group. A Pending instance which passes the health check
+// already forwards traffic, e.g. while a lifecycle hook waits for it.
+func (ni *NatInstance) InService() bool {
+	switch ni.LifecycleState {
+	case "",
+		autoscaling.LifecycleStateInService,
+		autoscaling.LifecycleStatePending,
+		autoscaling.LifecycleStatePendingWait,
+		autoscaling.LifecycleStatePendingProceed:
+		return true
+	}
+	return false
+}
+
+// ZoneKey returns the AZ ID of the Nat Instance, or the zone name if the AZ ID is unknown
+func (ni *NatInstance) ZoneKey() string {
+	return zoneKey(ni.Zone, ni.ZoneId)
 }
 
 // FindNatInstances returns a list of Nat Instances tagged for router
-func (r *AwsFinder) FindNatInstances(clusterId, vpcId string) ([]*NatInstance, error) {
+func (r *AwsFinder) FindNatInstances(ctx context.Context, clusterId, vpcId string) ([]*NatInstance, error) {
+	r.report = &Report{}
 	input := &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
 			{
-				Name: aws.String(fmt.Sprintf("tag:%v", clusterTag)),
+				Name: aws.String(fmt.Sprintf("tag:%v", r.tags.ClusterId)),
 				Values: []*string{
 					aws.String(clusterId),
 				},
@@ -43,29 +114,17 @@ func (r *AwsFinder) FindNatInstances(clusterId, vpcId string) ([]*NatInstance, e
 	}
 
 	var natInstances []*NatInstance
-	log.Debugf("Finding Instances with 'tag:%v=%v' and 'vpc-id=%v'", clusterTag, clusterId, vpcId)
-	err := r.ec2.DescribeInstancesPages(input,
+	zones := r.zoneIndex(ctx, vpcId)
+	log.Debugf("Finding Instances with 'tag:%v=%v' and 'vpc-id=%v'", r.tags.ClusterId, clusterId, vpcId)
+	err := r.ec2.DescribeInstancesPagesWithContext(ctx, input,
 		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
 			for _, res := range page.Reservations {
 				for _, i := range res.Instances {
-					ni := &NatInstance{
-						Id:              *i.InstanceId,
-						State:           *i.State.Name,
-						SourceDestCheck: *i.SourceDestCheck,
-						LaunchTime:      *i.LaunchTime,
-					}
-					if i.PrivateIpAddress != nil {
-						ni.PrivateIP = *i.PrivateIpAddress
-					}
-					if i.PrivateIpAddress != nil {
-						ni.PublicIP = *i.PublicIpAddress
+					ni := r.newNatInstance(i, zones)
+					if ni == nil {
+						continue
 					}
-					for _, t := range i.Tags {
-						if *t.Key == zoneTag {
-							ni.Zone = *t.Value
-						}
-					}
-					log.Debugf("Discovered %v (%v)", ni.Id, ni.PrivateIP)
+					log.Debugf("Discovered %v (%v) in %v", ni.Id, ni.PrivateIP, ni.ZoneKey())
 					natInstances = append(natInstances, ni)
 				}
 			}
@@ -78,3 +137,69 @@ func (r *AwsFinder) FindNatInstances(clusterId, vpcId string) ([]*NatInstance, e
 
 	return natInstances, nil
 }
+
+// newNatInstance converts an ec2 Instance resolving its zone in zones, malformed instances are added to the report
+// and nil is returned if the instance can not be used
+func (r *AwsFinder) newNatInstance(i *ec2.Instance, zones *zoneIndex) *NatInstance {
+	report := r.report
+	if i.InstanceId == nil {
+		report.skip("unknown instance", "missing InstanceId")
+		return nil
+	}
+	ni := &NatInstance{
+		Id: *i.InstanceId,
+	}
+	// LaunchTime decides leadership, an instance without it can not be ordered
+	if i.LaunchTime == nil {
+		report.skip(ni.Id, "missing LaunchTime")
+		return nil
+	}
+	ni.LaunchTime = *i.LaunchTime
+
+	if i.State != nil && i.State.Name != nil {
+		ni.State = *i.State.Name
+	} else {
+		report.flag(ni.Id, "missing State")
+	}
+	// assume SourceDestCheck is enabled so it will be disabled
+	ni.SourceDestCheck = true
+	if i.SourceDestCheck != nil {
+		ni.SourceDestCheck = *i.SourceDestCheck
+	} else {
+		report.flag(ni.Id, "missing SourceDestCheck")
+	}
+	if i.PrivateIpAddress != nil {
+		ni.PrivateIP = *i.PrivateIpAddress
+	} else {
+		report.flag(ni.Id, "missing PrivateIpAddress")
+	}
+	if i.PublicIpAddress != nil {
+		ni.PublicIP = *i.PublicIpAddress
+	}
+	ni.Spot = aws.StringValue(i.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot
+	_, ni.Drain = tagValue(i.Tags, r.tags.Drain)
+	if v, ok := tagValue(i.Tags, r.tags.Priority); ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			report.flag(ni.Id, "malformed %v=%v", r.tags.Priority, v)
+		}
+		ni.Priority = p
+	}
+
+	if i.Placement != nil && i.Placement.AvailabilityZone != nil {
+		ni.Zone, ni.ZoneId = zones.resolve(*i.Placement.AvailabilityZone)
+	}
+	// zone tag is an optional override, it may hold a zone name or AZ ID
+	if v, ok := tagValue(i.Tags, r.tags.Zone); ok {
+		name, id := zones.resolve(v)
+		if ni.Zone != "" && ni.ZoneKey() != zoneKey(name, id) {
+			log.Errorf("Configuration error: Instance %v is placed in %v but tagged %v=%v", ni.Id, ni.ZoneKey(), r.tags.Zone, v)
+			report.flag(ni.Id, "placed in %v but tagged %v=%v", ni.ZoneKey(), r.tags.Zone, v)
+		}
+		ni.Zone, ni.ZoneId = name, id
+	}
+	if ni.Zone == "" {
+		report.flag(ni.Id, "unknown zone")
+	}
+	return ni
+}
@@ -0,0 +1,56 @@
+package discover
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Issue describes a skipped or malformed resource found during discovery
+type Issue struct {
+	ResourceId string `json:"resourceId"`
+	Reason     string `json:"reason"`
+	// Skipped is true if the resource was left out of the discovered resources
+	Skipped bool `json:"skipped"`
+}
+
+// Report lists the issues found during discovery
+type Report struct {
+	Issues []Issue `json:"issues"`
+}
+
+// skip records a resource which was left out of the discovered resources
+func (r *Report) skip(resourceId, format string, args ...interface{}) {
+	r.add(Issue{ResourceId: resourceId, Reason: fmt.Sprintf(format, args...), Skipped: true})
+}
+
+// flag records a malformed resource which was still discovered
+func (r *Report) flag(resourceId, format string, args ...interface{}) {
+	r.add(Issue{ResourceId: resourceId, Reason: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) add(i Issue) {
+	r.Issues = append(r.Issues, i)
+}
+
+// Skipped returns the number of resources left out of the discovered resources
+func (r *Report) Skipped() int {
+	n := 0
+	for _, i := range r.Issues {
+		if i.Skipped {
+			n++
+		}
+	}
+	return n
+}
+
+// Log writes each issue of the report to the log
+func (r *Report) Log() {
+	for _, i := range r.Issues {
+		if i.Skipped {
+			log.Warnf("Skipped %v: %v", i.ResourceId, i.Reason)
+		} else {
+			log.Warnf("Malformed %v: %v", i.ResourceId, i.Reason)
+		}
+	}
+}
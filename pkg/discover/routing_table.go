@@ -1,7 +1,9 @@
 package discover
 
 import (
+	"context"
 	"fmt"
+	"sort"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -14,15 +16,37 @@ import (
 type RoutingTable struct {
 	Id                  string
 	Zone                string
+	ZoneId              string
 	EgressNatInstanceId string
+	// Blackhole is true if the egress route targets a stopped or terminated NAT Instance
+	Blackhole bool
+	// Subnets lists the subnets explicitly associated with the Routing Table
+	Subnets []string
+	// SubnetZones lists the distinct zones of the associated subnets
+	SubnetZones []string
+}
+
+// ZoneKey returns the AZ ID of the Routing Table, or the zone name if the AZ ID is unknown
+func (rt *RoutingTable) ZoneKey() string {
+	return zoneKey(rt.Zone, rt.ZoneId)
+}
+
+// MultiZone returns true if the Routing Table is associated with subnets in more than one zone
+func (rt *RoutingTable) MultiZone() bool {
+	return len(rt.SubnetZones) > 1
+}
+
+// Unassociated returns true if the Routing Table has no explicit subnet associations
+func (rt *RoutingTable) Unassociated() bool {
+	return len(rt.Subnets) == 0
 }
 
 // FindRoutingTables returns a list of Routing Tables to route through cluster
-func (r *AwsFinder) FindRoutingTables(clusterId, vpcId string) ([]*RoutingTable, error) {
+func (r *AwsFinder) FindRoutingTables(ctx context.Context, clusterId, vpcId string) ([]*RoutingTable, error) {
 	input := &ec2.DescribeRouteTablesInput{
 		Filters: []*ec2.Filter{
 			{
-				Name: aws.String(fmt.Sprintf("tag:%v", clusterTag)),
+				Name: aws.String(fmt.Sprintf("tag:%v", r.tags.ClusterId)),
 				Values: []*string{
 					aws.String(clusterId),
 				},
@@ -34,32 +58,127 @@ func (r *AwsFinder) FindRoutingTables(clusterId, vpcId string) ([]*RoutingTable,
 				},
 			},
 		},
+		MaxResults: aws.Int64(100),
 	}
 
-	log.Debugf("Finding RoutingTables with 'tag:%v=%v' and 'vpc-id=%v'", clusterTag, clusterId, vpcId)
-	result, err := r.ec2.DescribeRouteTables(input)
+	log.Debugf("Finding RoutingTables with 'tag:%v=%v' and 'vpc-id=%v'", r.tags.ClusterId, clusterId, vpcId)
+	var routeTables []*ec2.RouteTable
+	err := r.ec2.DescribeRouteTablesPagesWithContext(ctx, input,
+		func(page *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+			routeTables = append(routeTables, page.RouteTables...)
+			// to stop iterating, return false
+			return true
+		})
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to find RoutingTables")
 	}
 
-	var routingTables []*RoutingTable
-	for _, r := range result.RouteTables {
-		rt := &RoutingTable{
-			Id: *r.RouteTableId,
+	subnetZones, err := r.findSubnetZones(ctx, vpcId)
+	if err != nil {
+		return nil, err
+	}
+	zones := r.zoneIndex(ctx, vpcId)
+	routingTables := make([]*RoutingTable, 0, len(routeTables))
+	for _, t := range routeTables {
+		rt := r.newRoutingTable(t, subnetZones, zones)
+		if rt == nil {
+			continue
 		}
-		for _, route := range r.Routes {
-			// hardcoding egress = 0.0.0.0/0
-			if *route.DestinationCidrBlock == "0.0.0.0/0" && route.InstanceId != nil {
-				rt.EgressNatInstanceId = *route.InstanceId
-			}
+		log.Debugf("Discovered %v (%v)", rt.Id, rt.ZoneKey())
+		routingTables = append(routingTables, rt)
+	}
+	return routingTables, nil
+}
+
+// newRoutingTable converts an ec2 RouteTable resolving its zone in zones, malformed route tables are added to the report
+// and nil is returned if the route table can not be used
+func (r *AwsFinder) newRoutingTable(t *ec2.RouteTable, subnetZones map[string]string, zones *zoneIndex) *RoutingTable {
+	report := r.report
+	if t.RouteTableId == nil {
+		report.skip("unknown route table", "missing RouteTableId")
+		return nil
+	}
+	rt := &RoutingTable{
+		Id: *t.RouteTableId,
+	}
+	for _, route := range t.Routes {
+		// hardcoding egress = 0.0.0.0/0, ipv6 and prefix list routes have no DestinationCidrBlock
+		if route.DestinationCidrBlock != nil && *route.DestinationCidrBlock == "0.0.0.0/0" && route.InstanceId != nil {
+			rt.EgressNatInstanceId = *route.InstanceId
+			rt.Blackhole = aws.StringValue(route.State) == ec2.RouteStateBlackhole
 		}
+	}
 
-		for _, t := range r.Tags {
-			if *t.Key == zoneTag {
-				rt.Zone = *t.Value
-			}
+	// derive zone from associated subnets, the main association has no SubnetId
+	seen := make(map[string]bool)
+	for _, a := range t.Associations {
+		if a.SubnetId == nil {
+			continue
+		}
+		rt.Subnets = append(rt.Subnets, *a.SubnetId)
+		z, ok := subnetZones[*a.SubnetId]
+		if !ok {
+			report.flag(rt.Id, "associated subnet %v not found", *a.SubnetId)
+			continue
+		}
+		if !seen[z] {
+			seen[z] = true
+			rt.SubnetZones = append(rt.SubnetZones, z)
 		}
-		routingTables = append(routingTables, rt)
 	}
-	return routingTables, nil
+	sort.Strings(rt.SubnetZones)
+	if len(rt.SubnetZones) == 1 {
+		rt.Zone, rt.ZoneId = zones.resolve(rt.SubnetZones[0])
+	}
+
+	// zone tag is an optional override, it may hold a zone name or AZ ID
+	if v, ok := tagValue(t.Tags, r.tags.Zone); ok {
+		name, id := zones.resolve(v)
+		if rt.Zone != "" && rt.ZoneKey() != zoneKey(name, id) {
+			log.Debugf("RoutingTable %v zone %v overridden by tag %v=%v", rt.Id, rt.ZoneKey(), r.tags.Zone, v)
+		}
+		rt.Zone, rt.ZoneId = name, id
+		return rt
+	}
+
+	switch {
+	case rt.Unassociated():
+		report.flag(rt.Id, "no subnet associations, tag it with %v to set a zone", r.tags.Zone)
+	case rt.MultiZone():
+		report.flag(rt.Id, "subnets in multiple zones %v, tag it with %v to set a zone", rt.SubnetZones, r.tags.Zone)
+	}
+	return rt
+}
+
+// findSubnetZones returns the AvailabilityZone of every subnet in the vpc indexed by SubnetId,
+// adding the AZ IDs of the subnets to the zone index
+func (r *AwsFinder) findSubnetZones(ctx context.Context, vpcId string) (map[string]string, error) {
+	input := &ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name: aws.String("vpc-id"),
+				Values: []*string{
+					aws.String(vpcId),
+				},
+			},
+		},
+	}
+
+	log.Debugf("Finding Subnets with 'vpc-id=%v'", vpcId)
+	result, err := r.ec2.DescribeSubnetsWithContext(ctx, input)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find Subnets")
+	}
+
+	zones := make(map[string]string)
+	for _, s := range result.Subnets {
+		if s.SubnetId == nil || s.AvailabilityZone == nil {
+			continue
+		}
+		zones[*s.SubnetId] = *s.AvailabilityZone
+		if s.AvailabilityZoneId != nil {
+			r.zones.add(*s.AvailabilityZone, *s.AvailabilityZoneId)
+		}
+	}
+	return zones, nil
 }
@@ -0,0 +1,88 @@
+package discover
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pkg/errors"
+)
+
+// Zone names such as ap-southeast-1a map to different physical zones in different accounts,
+// AZ IDs such as apse1-az1 are consistent across accounts.
+
+// zoneIndex maps AvailabilityZone names to AZ IDs and back
+type zoneIndex struct {
+	mu    sync.Mutex
+	ids   map[string]string // name -> id
+	names map[string]string // id -> name
+	// complete is true once every zone of the region was added
+	complete bool
+}
+
+func newZoneIndex() *zoneIndex {
+	return &zoneIndex{
+		ids:   make(map[string]string),
+		names: make(map[string]string),
+	}
+}
+
+// add records the AZ ID of a zone name
+func (z *zoneIndex) add(name, id string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.ids[name] = id
+	z.names[id] = name
+}
+
+// resolve accepts either a zone name or an AZ ID and returns both if known
+func (z *zoneIndex) resolve(zone string) (name, id string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if n, ok := z.names[zone]; ok {
+		return n, zone
+	}
+	return zone, z.ids[zone]
+}
+
+// zoneIndex returns the zone index for the region, it is looked up until it was found once per AwsFinder.
+// Instance placement only holds the zone name, so the AZ IDs of instances are resolved here. While
+// DescribeAvailabilityZones fails, the AZ IDs are learned from the subnets of the vpc instead.
+func (r *AwsFinder) zoneIndex(ctx context.Context, vpcId string) *zoneIndex {
+	r.zones.mu.Lock()
+	complete := r.zones.complete
+	r.zones.mu.Unlock()
+	if complete {
+		return r.zones
+	}
+
+	log.Debug("Finding AvailabilityZones")
+	result, err := r.ec2.DescribeAvailabilityZonesWithContext(ctx, &ec2.DescribeAvailabilityZonesInput{})
+	if err != nil {
+		log.Warnf("Falling back to the zones of subnets: %v", errors.Wrap(err, "Unable to find AvailabilityZones"))
+		if _, err := r.findSubnetZones(ctx, vpcId); err != nil {
+			log.Warnf("Falling back to zone names: %v", err)
+		}
+		return r.zones
+	}
+	for _, az := range result.AvailabilityZones {
+		if az.ZoneName == nil || az.ZoneId == nil {
+			continue
+		}
+		r.zones.add(*az.ZoneName, *az.ZoneId)
+	}
+	r.zones.mu.Lock()
+	r.zones.complete = true
+	r.zones.mu.Unlock()
+	return r.zones
+}
+
+// zoneKey returns the AZ ID if it is known, falling back to the zone name
+func zoneKey(name, id string) string {
+	if id != "" {
+		return id
+	}
+	return name
+}
@@ -0,0 +1,325 @@
+package election
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+)
+
+// attributes of a lease item, the table is keyed by LockId
+const (
+	attrLockId = "LockId"
+	attrHolder = "Holder"
+	// attrExpires holds the expiry of the lease in unix milliseconds
+	attrExpires = "Expires"
+	// attrTerm is incremented every time the lease changes hands
+	attrTerm = "Term"
+	// attrHeartbeat holds the time of the last successful reconciliation of the holder in unix milliseconds
+	attrHeartbeat = "Heartbeat"
+)
+
+// DynamoDBElector elects a leader through a lease item in a DynamoDB table, written with conditional writes.
+// The leader renews the lease on every reconciliation and in between, another node takes over once the lease expires.
+// Expiry is compared with the clock of each node, so clocks should be kept in sync (e.g. with NTP).
+type DynamoDBElector struct {
+	db     dynamodbiface.DynamoDBAPI
+	table  string
+	lockId string
+	holder string
+	ttl    time.Duration
+
+	mu   sync.Mutex
+	held bool
+	term int64
+	// expires is the expiry of the lease last written by this node
+	expires time.Time
+	// observed is the term of the lease last read by LastHeartbeat
+	observed int64
+}
+
+// NewDynamoDBElectorFromSession returns DynamoDBElector from session, cfgs may override the DynamoDB endpoint
+func NewDynamoDBElectorFromSession(session *session.Session, table, lockId, holder string, ttl time.Duration, cfgs ...*aws.Config) (*DynamoDBElector, error) {
+	return NewDynamoDBElector(dynamodb.New(session, cfgs...), table, lockId, holder, ttl)
+}
+
+// NewDynamoDBElector returns DynamoDBElector for the lease lockId in table, held by holder for ttl after each renewal
+func NewDynamoDBElector(svc dynamodbiface.DynamoDBAPI, table, lockId, holder string, ttl time.Duration) (*DynamoDBElector, error) {
+	if table == "" {
+		return nil, errors.New("DynamoDB table can not be blank")
+	}
+	if holder == "" {
+		return nil, errors.New("lease holder can not be blank")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("lease ttl should be positive")
+	}
+	return &DynamoDBElector{
+		db:     svc,
+		table:  table,
+		lockId: lockId,
+		holder: holder,
+		ttl:    ttl,
+	}, nil
+}
+
+// IsLeader acquires or renews the lease, it returns false if another node holds the lease.
+// Errors leave leadership unconfirmed for this cycle, the lease is renewed in the next cycle if it was not taken over.
+func (e *DynamoDBElector) IsLeader(ctx context.Context, live []*discover.NatInstance) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(e.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			attrLockId: {S: aws.String(e.lockId)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#holder":  aws.String(attrHolder),
+			"#expires": aws.String(attrExpires),
+			"#term":    aws.String(attrTerm),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder":  {S: aws.String(e.holder)},
+			":expires": {N: aws.String(millis(now.Add(e.ttl)))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	}
+	if e.held {
+		// renew, fails if the lease expired and was taken over meanwhile
+		input.UpdateExpression = aws.String("SET #expires = :expires")
+		input.ConditionExpression = aws.String("#holder = :holder AND #term = :term")
+		input.ExpressionAttributeValues[":term"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(e.term, 10))}
+	} else {
+		// acquire a free or expired lease, or our own lease after a restart, starting a new term
+		input.UpdateExpression = aws.String("SET #holder = :holder, #expires = :expires, #term = if_not_exists(#term, :zero) + :one")
+		input.ConditionExpression = aws.String("attribute_not_exists(#holder) OR #holder = :holder OR #expires < :now")
+		input.ExpressionAttributeValues[":now"] = &dynamodb.AttributeValue{N: aws.String(millis(now))}
+		input.ExpressionAttributeValues[":zero"] = &dynamodb.AttributeValue{N: aws.String("0")}
+		input.ExpressionAttributeValues[":one"] = &dynamodb.AttributeValue{N: aws.String("1")}
+	}
+
+	return e.update(ctx, input)
+}
+
+// Usurp takes the lease from leader even if it did not expire, provided it was not renewed by another term since
+// LastHeartbeat. The leader steps down once its next renewal fails.
+func (e *DynamoDBElector) Usurp(ctx context.Context, leader string, live []*discover.NatInstance) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	log.Infof("Taking over lease %v from %v (term %v)", e.lockId, leader, e.observed)
+	return e.update(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(e.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			attrLockId: {S: aws.String(e.lockId)},
+		},
+		UpdateExpression:    aws.String("SET #holder = :holder, #expires = :expires, #term = #term + :one"),
+		ConditionExpression: aws.String("#holder = :leader AND #term = :term"),
+		ExpressionAttributeNames: map[string]*string{
+			"#holder":  aws.String(attrHolder),
+			"#expires": aws.String(attrExpires),
+			"#term":    aws.String(attrTerm),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder":  {S: aws.String(e.holder)},
+			":expires": {N: aws.String(millis(time.Now().Add(e.ttl)))},
+			":leader":  {S: aws.String(leader)},
+			":term":    {N: aws.String(strconv.FormatInt(e.observed, 10))},
+			":one":     {N: aws.String("1")},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+}
+
+// update writes the lease with input, a failed condition means another node holds the lease
+func (e *DynamoDBElector) update(ctx context.Context, input *dynamodb.UpdateItemInput) (bool, error) {
+	result, err := e.db.UpdateItemWithContext(ctx, input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			if e.held {
+				log.Warnf("Lost lease %v (term %v) to another node", e.lockId, e.term)
+			}
+			e.held, e.term, e.expires = false, 0, time.Time{}
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "Unable to renew lease %v", e.lockId)
+	}
+
+	var n *string
+	if v, ok := result.Attributes[attrTerm]; ok && v != nil {
+		n = v.N
+	}
+	term, err := strconv.ParseInt(aws.StringValue(n), 10, 64)
+	if err != nil {
+		return false, errors.Wrapf(err, "Unable to parse term of lease %v", e.lockId)
+	}
+	if !e.held {
+		log.Infof("Acquired lease %v (term %v)", e.lockId, term)
+	}
+	_, _, expires, _ := parseLease(result.Attributes)
+	e.held, e.term, e.expires = true, term, time.Unix(0, expires*int64(time.Millisecond))
+	return true, nil
+}
+
+// Heartbeat records a successful reconciliation in the lease, it does nothing unless this node leads
+func (e *DynamoDBElector) Heartbeat(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.held {
+		return nil
+	}
+	_, err := e.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(e.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			attrLockId: {S: aws.String(e.lockId)},
+		},
+		UpdateExpression:    aws.String("SET #heartbeat = :now"),
+		ConditionExpression: aws.String("#holder = :holder AND #term = :term"),
+		ExpressionAttributeNames: map[string]*string{
+			"#holder":    aws.String(attrHolder),
+			"#term":      aws.String(attrTerm),
+			"#heartbeat": aws.String(attrHeartbeat),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(e.holder)},
+			":term":   {N: aws.String(strconv.FormatInt(e.term, 10))},
+			":now":    {N: aws.String(millis(time.Now()))},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			// the next renewal finds the lease taken over
+			return nil
+		}
+		return errors.Wrapf(err, "Unable to write heartbeat of lease %v", e.lockId)
+	}
+	return nil
+}
+
+// LastHeartbeat returns the holder of the lease and its last heartbeat
+func (e *DynamoDBElector) LastHeartbeat(ctx context.Context, live []*discover.NatInstance) (string, time.Time, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result, err := e.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(e.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			attrLockId: {S: aws.String(e.lockId)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "Unable to read lease %v", e.lockId)
+	}
+	holder, term, _, heartbeat := parseLease(result.Item)
+	e.observed = term
+	if heartbeat == 0 {
+		return holder, time.Time{}, nil
+	}
+	return holder, time.Unix(0, heartbeat*int64(time.Millisecond)), nil
+}
+
+// Term returns the term of the lease held by this node, 0 if it does not hold the lease
+func (e *DynamoDBElector) Term() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.term
+}
+
+// Expires returns when the lease held by this node expires, zero if it does not hold the lease
+func (e *DynamoDBElector) Expires() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.expires
+}
+
+// CheckTerm reads the lease and returns an error caused by ErrStaleTerm unless this node still holds it in term
+func (e *DynamoDBElector) CheckTerm(ctx context.Context, term int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result, err := e.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(e.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			attrLockId: {S: aws.String(e.lockId)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Unable to read lease %v", e.lockId)
+	}
+	holder, stored, expires, _ := parseLease(result.Item)
+	if holder != e.holder || stored != term || expires <= time.Now().UnixNano()/int64(time.Millisecond) {
+		return errors.Wrapf(ErrStaleTerm, "lease %v is held by %v in term %v, not in term %v", e.lockId, holder, stored, term)
+	}
+	return nil
+}
+
+// Resign expires the lease if this node holds it, so another node can take over without waiting for the ttl
+func (e *DynamoDBElector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.held {
+		return nil
+	}
+	e.held, e.expires = false, time.Time{}
+	_, err := e.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(e.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			attrLockId: {S: aws.String(e.lockId)},
+		},
+		// keep the term, it must keep increasing
+		UpdateExpression:    aws.String("SET #expires = :zero"),
+		ConditionExpression: aws.String("#holder = :holder AND #term = :term"),
+		ExpressionAttributeNames: map[string]*string{
+			"#holder":  aws.String(attrHolder),
+			"#expires": aws.String(attrExpires),
+			"#term":    aws.String(attrTerm),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(e.holder)},
+			":term":   {N: aws.String(strconv.FormatInt(e.term, 10))},
+			":zero":   {N: aws.String("0")},
+		},
+	})
+	e.term = 0
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			// the lease was already taken over
+			return nil
+		}
+		return errors.Wrapf(err, "Unable to resign lease %v", e.lockId)
+	}
+	log.Infof("Resigned lease %v", e.lockId)
+	return nil
+}
+
+// parseLease returns the attributes of a lease item, missing or malformed numbers are 0
+func parseLease(item map[string]*dynamodb.AttributeValue) (holder string, term, expires, heartbeat int64) {
+	if v, ok := item[attrHolder]; ok && v != nil {
+		holder = aws.StringValue(v.S)
+	}
+	number := func(name string) int64 {
+		v, ok := item[name]
+		if !ok || v == nil {
+			return 0
+		}
+		n, _ := strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+		return n
+	}
+	return holder, number(attrTerm), number(attrExpires), number(attrHeartbeat)
+}
+
+// millis formats t as unix milliseconds
+func millis(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
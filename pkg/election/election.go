@@ -0,0 +1,97 @@
+package election
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+)
+
+// ErrStaleTerm is returned when a leader of an older term tries to apply its plan
+var ErrStaleTerm = errors.New("stale leadership term")
+
+// Elector decides if this node leads a target, only the leader updates routes
+type Elector interface {
+	// IsLeader returns true if this node leads, live holds the healthy NAT Instances ranked for leadership.
+	// It is called once per reconciliation and renews leadership where leases are used, a leader also calls it
+	// between reconciliations to renew its lease with live nil.
+	IsLeader(ctx context.Context, live []*discover.NatInstance) (bool, error)
+	// Heartbeat records a successful reconciliation of the leader where the backend keeps one
+	Heartbeat(ctx context.Context) error
+	// Resign gives up leadership, e.g. on shutdown
+	Resign(ctx context.Context) error
+}
+
+// Fencer is an Elector whose terms serve as fencing tokens, the term increases every time leadership changes hands
+type Fencer interface {
+	Elector
+	// Term returns the term of the leadership of this node, 0 if it does not lead or terms are not kept
+	Term() int64
+	// CheckTerm returns an error caused by ErrStaleTerm unless this node still holds the stored lease of term
+	CheckTerm(ctx context.Context, term int64) error
+}
+
+// Leaser is an Elector whose leadership is a lease, a leader must not act on its leadership once the lease expired
+type Leaser interface {
+	Elector
+	// Expires returns when the lease held by this node expires, zero if it does not hold the lease
+	Expires() time.Time
+}
+
+// AlwaysElector makes every node the leader, for a single router per target
+type AlwaysElector struct{}
+
+// IsLeader always returns true
+func (AlwaysElector) IsLeader(ctx context.Context, live []*discover.NatInstance) (bool, error) {
+	return true, nil
+}
+
+// Heartbeat does nothing
+func (AlwaysElector) Heartbeat(ctx context.Context) error {
+	return nil
+}
+
+// Resign does nothing
+func (AlwaysElector) Resign(ctx context.Context) error {
+	return nil
+}
+
+// OldestElector makes the node on the first live NAT Instance the leader, by default the oldest, see Rank.
+// Nodes with a different view of health may both consider themselves leader.
+type OldestElector struct {
+	instanceId string
+}
+
+// NewOldestElector returns OldestElector for the node running on instanceId
+func NewOldestElector(instanceId string) *OldestElector {
+	return &OldestElector{
+		instanceId: instanceId,
+	}
+}
+
+// IsLeader returns true if this node runs on the oldest live NAT Instance
+func (e *OldestElector) IsLeader(ctx context.Context, live []*discover.NatInstance) (bool, error) {
+	return len(live) > 0 && live[0].Id == e.instanceId, nil
+}
+
+// Heartbeat does nothing
+func (e *OldestElector) Heartbeat(ctx context.Context) error {
+	return nil
+}
+
+// Usurp returns true if this node runs on the oldest live NAT Instance after leader,
+// so only one node takes over from a leader which stopped reconciling
+func (e *OldestElector) Usurp(ctx context.Context, leader string, live []*discover.NatInstance) (bool, error) {
+	for _, ni := range live {
+		if ni.Id != leader {
+			return ni.Id == e.instanceId, nil
+		}
+	}
+	return false, nil
+}
+
+// Resign does nothing, the next oldest live NAT Instance leads once this one is unhealthy
+func (e *OldestElector) Resign(ctx context.Context) error {
+	return nil
+}
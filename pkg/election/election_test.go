@@ -0,0 +1,300 @@
+package election_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+	"github.com/so0k/aws-nat-router/pkg/fake"
+)
+
+func TestOldestElector(t *testing.T) {
+	live := []*discover.NatInstance{{Id: "i-old"}, {Id: "i-new"}}
+	for id, want := range map[string]bool{"i-old": true, "i-new": false} {
+		if got, _ := election.NewOldestElector(id).IsLeader(context.Background(), live); got != want {
+			t.Errorf("%v IsLeader = %v, want %v", id, got, want)
+		}
+	}
+	if got, _ := election.NewOldestElector("i-old").IsLeader(context.Background(), nil); got {
+		t.Error("IsLeader without live instances")
+	}
+}
+
+// TestDynamoDBElector runs against DynamoDB Local, e.g.
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	NAT_TEST_DYNAMODB_ENDPOINT=http://localhost:8000 go test ./pkg/election/...
+func TestDynamoDBElector(t *testing.T) {
+	endpoint := os.Getenv("NAT_TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("NAT_TEST_DYNAMODB_ENDPOINT not set")
+	}
+	db := dynamodb.New(session.New(aws.NewConfig().
+		WithEndpoint(endpoint).
+		WithRegion("local").
+		WithCredentials(credentials.NewStaticCredentials("test", "test", ""))))
+	table := fmt.Sprintf("aws-nat-router-test-%v", time.Now().UnixNano())
+	_, err := db.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("LockId"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("LockId"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := time.Second
+	a, _ := election.NewDynamoDBElector(db, table, "vpc-1/squid", "i-a", ttl)
+	b, _ := election.NewDynamoDBElector(db, table, "vpc-1/squid", "i-b", ttl)
+	expect := func(e *election.DynamoDBElector, want bool, term int64) {
+		t.Helper()
+		got, err := e.IsLeader(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want || e.Term() != term {
+			t.Fatalf("IsLeader = %v (term %v), want %v (term %v)", got, e.Term(), want, term)
+		}
+	}
+
+	expect(a, true, 1)
+	expect(b, false, 0)
+	// renewals keep the term
+	expect(a, true, 1)
+
+	// b takes over once a stops renewing
+	time.Sleep(ttl + 100*time.Millisecond)
+	expect(b, true, 2)
+	expect(a, false, 0)
+
+	// a takes over without waiting once b resigns
+	if err := b.Resign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expect(a, true, 3)
+
+	// b takes over from a leader which stopped reconciling
+	if err := a.Heartbeat(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	holder, at, err := b.LastHeartbeat(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "i-a" || at.IsZero() {
+		t.Fatalf("LastHeartbeat = %v, %v, want i-a", holder, at)
+	}
+	if ok, err := b.Usurp(context.Background(), "i-a", nil); err != nil || !ok {
+		t.Fatalf("Usurp = %v, %v", ok, err)
+	}
+	expect(a, false, 0)
+	expect(b, true, 4)
+}
+
+func TestTagElector(t *testing.T) {
+	f := fake.NewEC2()
+	tags := map[string]string{discover.DefaultTags.ClusterId: "squid"}
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-2", VpcId: "vpc-1", Tags: tags})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-1", VpcId: "vpc-1", Tags: tags})
+	newElector := func(holder string) *election.TagElector {
+		e, err := election.NewTagElector(f, discover.DefaultTags, "vpc-1", "squid", "", holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Settle = 0
+		return e
+	}
+	a, b := newElector("i-a"), newElector("i-b")
+	expect := func(e *election.TagElector, want bool, term int64) {
+		t.Helper()
+		got, err := e.IsLeader(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want || e.Term() != term {
+			t.Fatalf("IsLeader = %v (term %v), want %v (term %v)", got, e.Term(), want, term)
+		}
+	}
+
+	expect(a, true, 1)
+	expect(b, false, 0)
+	expect(a, true, 1)
+	if !a.Expires().After(time.Now()) || !b.Expires().IsZero() {
+		t.Errorf("Expires = %v, %v, want a in the future", a.Expires(), b.Expires())
+	}
+	if got := f.Tag("rtb-1", discover.DefaultTags.LeaseHolder); got != "i-a" {
+		t.Errorf("lease held by %q on rtb-1, want i-a", got)
+	}
+	if err := a.Heartbeat(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if lease, _ := b.ReadLease(context.Background()); lease.Heartbeat.IsZero() {
+		t.Error("heartbeat not written")
+	}
+
+	// b takes over an expired lease, a notices on renewal
+	expire := func() {
+		f.CreateTags(&ec2.CreateTagsInput{
+			Resources: []*string{aws.String("rtb-1")},
+			Tags: []*ec2.Tag{
+				{Key: aws.String(discover.DefaultTags.LeaseExpires), Value: aws.String(time.Now().Add(-time.Second).Format(time.RFC3339Nano))},
+			},
+		})
+	}
+	expire()
+	expect(b, true, 2)
+	expect(a, false, 0)
+
+	// a lease overwritten by another node is given up
+	f.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String("rtb-1")},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(discover.DefaultTags.LeaseHolder), Value: aws.String("i-c")},
+			{Key: aws.String(discover.DefaultTags.LeaseTerm), Value: aws.String("3")},
+		},
+	})
+	expect(b, false, 0)
+
+	// resigning lets another node take over without waiting for the ttl
+	expire()
+	expect(a, true, 4)
+	if err := a.Resign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expect(b, true, 5)
+
+	// a Routing Table added with a lower id does not move the lease
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-0", VpcId: "vpc-1", Tags: tags})
+	c := newElector("i-c")
+	expect(c, false, 0)
+	expect(a, false, 0)
+	if got := f.Tag("rtb-0", discover.DefaultTags.LeaseHolder); got != "" {
+		t.Errorf("lease held by %q on rtb-0, want none", got)
+	}
+
+	// a malformed term is not taken over
+	f.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String("rtb-1")},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(discover.DefaultTags.LeaseTerm), Value: aws.String("five")},
+		},
+	})
+	expire()
+	if ok, err := c.IsLeader(context.Background(), nil); ok || err == nil {
+		t.Errorf("IsLeader with malformed term = %v, %v, want error", ok, err)
+	}
+	if ok, err := b.IsLeader(context.Background(), nil); ok || err == nil {
+		t.Errorf("IsLeader with malformed term = %v, %v, want error", ok, err)
+	}
+
+	// a hung call is abandoned once the context is done
+	f.Hang("DescribeTags")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.IsLeader(ctx, nil); err == nil {
+		t.Error("IsLeader with hung DescribeTags succeeded, want error")
+	}
+}
+
+// heartbeats reports a fixed leader and heartbeat
+type heartbeats struct {
+	leader string
+	at     time.Time
+}
+
+func (h *heartbeats) LastHeartbeat(ctx context.Context, live []*discover.NatInstance) (string, time.Time, error) {
+	return h.leader, h.at, nil
+}
+
+func TestWatchdog(t *testing.T) {
+	live := []*discover.NatInstance{{Id: "i-a"}, {Id: "i-b"}, {Id: "i-c"}}
+	h := &heartbeats{leader: "i-a", at: time.Now()}
+	maxAge := 20 * time.Millisecond
+	b := election.NewWatchdog(election.NewOldestElector("i-b"), h, "i-b", maxAge)
+	c := election.NewWatchdog(election.NewOldestElector("i-c"), h, "i-c", maxAge)
+	expect := func(w *election.Watchdog, want bool) {
+		t.Helper()
+		if got, err := w.IsLeader(context.Background(), live); err != nil || got != want {
+			t.Fatalf("IsLeader = %v, %v, want %v", got, err, want)
+		}
+	}
+
+	expect(b, false)
+	expect(c, false)
+	time.Sleep(maxAge + 10*time.Millisecond)
+	// only the next oldest takes over
+	expect(b, true)
+	expect(c, false)
+	if tk := b.Takeover(); tk == nil || tk.From != "i-a" || !tk.LastHeartbeat.Equal(h.at) {
+		t.Errorf("Takeover = %+v, want from i-a", tk)
+	}
+
+	// the leader reconciles again
+	h.at = time.Now()
+	expect(b, false)
+	if tk := b.Takeover(); tk != nil {
+		t.Errorf("Takeover = %+v, want none", tk)
+	}
+}
+
+func TestRank(t *testing.T) {
+	launched := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	nis := func() []*discover.NatInstance {
+		return []*discover.NatInstance{
+			{Id: "i-c", LaunchTime: launched.Add(time.Hour), Priority: 10},
+			{Id: "i-b", LaunchTime: launched, Spot: true, Priority: 20},
+			{Id: "i-a", LaunchTime: launched.Add(2 * time.Hour)},
+		}
+	}
+	for _, tc := range []struct {
+		order          string
+		preferOnDemand bool
+		want           string
+	}{
+		{election.OrderOldest, false, "i-b i-c i-a"},
+		{election.OrderOldest, true, "i-c i-a i-b"},
+		{election.OrderLowestId, false, "i-a i-b i-c"},
+		{election.OrderLowestId, true, "i-a i-c i-b"},
+		{election.OrderPriority, false, "i-b i-c i-a"},
+		{election.OrderPriority, true, "i-c i-a i-b"},
+	} {
+		live := nis()
+		election.Rank(live, tc.order, tc.preferOnDemand)
+		if got := ids(live); got != tc.want {
+			t.Errorf("Rank %v (prefer on-demand %v) = %v, want %v", tc.order, tc.preferOnDemand, got, tc.want)
+		}
+	}
+}
+
+func TestStick(t *testing.T) {
+	live := []*discover.NatInstance{{Id: "i-a"}, {Id: "i-b"}, {Id: "i-c"}}
+	if !election.Stick(live, "i-c") || ids(live) != "i-c i-a i-b" {
+		t.Errorf("Stick i-c = %v, want i-c i-a i-b", ids(live))
+	}
+	if election.Stick(live, "i-gone") || ids(live) != "i-c i-a i-b" {
+		t.Errorf("Stick i-gone = %v, want unchanged", ids(live))
+	}
+}
+
+func ids(nis []*discover.NatInstance) string {
+	var s []string
+	for _, ni := range nis {
+		s = append(s, ni.Id)
+	}
+	return strings.Join(s, " ")
+}
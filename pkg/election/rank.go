@@ -0,0 +1,54 @@
+package election
+
+import (
+	"sort"
+
+	"github.com/so0k/aws-nat-router/pkg/discover"
+)
+
+// Orders rank live NAT Instances for leadership
+const (
+	OrderOldest   = "oldest"
+	OrderLowestId = "lowest-id"
+	// OrderPriority ranks by the priority tag, highest first
+	OrderPriority = "priority"
+)
+
+// Orders lists the supported orders
+var Orders = []string{OrderOldest, OrderLowestId, OrderPriority}
+
+// Rank sorts NAT Instances for leadership by order, putting on-demand before Spot Instances if preferOnDemand is set.
+// Ties are broken by LaunchTime and id, so every node ranks the same NAT Instances alike.
+func Rank(nis []*discover.NatInstance, order string, preferOnDemand bool) {
+	sort.SliceStable(nis, func(i, j int) bool {
+		a, b := nis[i], nis[j]
+		if preferOnDemand && a.Spot != b.Spot {
+			return !a.Spot
+		}
+		switch order {
+		case OrderLowestId:
+			return a.Id < b.Id
+		case OrderPriority:
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+		}
+		if !a.LaunchTime.Equal(b.LaunchTime) {
+			return a.LaunchTime.Before(b.LaunchTime)
+		}
+		return a.Id < b.Id
+	})
+}
+
+// Stick moves the NAT Instance leader to the front of ranked live NAT Instances, so it keeps leading while live.
+// It returns false if leader is not live.
+func Stick(live []*discover.NatInstance, leader string) bool {
+	for i, ni := range live {
+		if ni.Id == leader {
+			copy(live[1:i+1], live[:i])
+			live[0] = ni
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,368 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+)
+
+// DefaultSettle is how long TagElector waits before reading back a lease it wrote
+const DefaultSettle = 2 * time.Second
+
+// Lease is the leader lease stored in tags
+type Lease struct {
+	Holder  string
+	Term    int64
+	Expires time.Time
+	// Heartbeat is the time of the last successful reconciliation of the holder, zero if unknown
+	Heartbeat time.Time
+}
+
+// TagElector elects a leader through a lease stored as tags on an EC2 resource, using only EC2 APIs.
+// The leader renews the lease on every reconciliation and in between, another node takes over once the lease expires.
+//
+// Tags have no conditional writes: a node taking over writes the lease, waits for Settle and reads it back,
+// so that nodes taking over at the same time agree on the last writer. A node which finds its lease
+// overwritten steps down. Expiry is compared with the clock of each node, so clocks should be kept in sync.
+type TagElector struct {
+	ec2       ec2iface.EC2API
+	tags      discover.Tags
+	vpcId     string
+	clusterId string
+	holder    string
+	ttl       time.Duration
+	// Settle is how long to wait before reading back a lease this node wrote when taking over
+	Settle time.Duration
+
+	mu       sync.Mutex
+	resource string
+	// fixed is true if the resource was configured rather than looked up
+	fixed bool
+	held  bool
+	term  int64
+	// expires is the expiry of the lease last written by this node
+	expires time.Time
+}
+
+// NewTagElector returns TagElector for the lease on resource held by holder for ttl after each renewal.
+// A blank resource uses the Routing Table of the cluster holding the lease of the highest term,
+// or the one with the lowest id until a lease was written.
+func NewTagElector(svc ec2iface.EC2API, tags discover.Tags, vpcId, clusterId, resource, holder string, ttl time.Duration) (*TagElector, error) {
+	if holder == "" {
+		return nil, errors.New("lease holder can not be blank")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("lease ttl should be positive")
+	}
+	return &TagElector{
+		ec2:       svc,
+		tags:      tags,
+		vpcId:     vpcId,
+		clusterId: clusterId,
+		holder:    holder,
+		ttl:       ttl,
+		Settle:    DefaultSettle,
+		resource:  resource,
+		fixed:     resource != "",
+	}, nil
+}
+
+// IsLeader acquires or renews the lease, it returns false if another node holds an unexpired lease
+// or overwrote the lease held by this node.
+func (e *TagElector) IsLeader(ctx context.Context, live []*discover.NatInstance) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.acquire(ctx, "")
+}
+
+// Usurp takes the lease from leader even if it did not expire, leader steps down once it finds its lease overwritten
+func (e *TagElector) Usurp(ctx context.Context, leader string, live []*discover.NatInstance) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.acquire(ctx, leader)
+}
+
+// acquire acquires or renews the lease, an unexpired lease is only taken over if it is held by usurp
+func (e *TagElector) acquire(ctx context.Context, usurp string) (bool, error) {
+	if !e.held && !e.fixed {
+		// the resource may have been replaced while another node led, a lease pins it until it is removed
+		resource, err := e.findResource(ctx)
+		if err != nil {
+			return false, err
+		}
+		e.resource = resource
+	}
+
+	lease, err := e.readLease(ctx)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if e.held && (lease.Holder != e.holder || lease.Term != e.term) {
+		log.Warnf("Lease on %v (term %v) was taken over by %v (term %v)", e.resource, e.term, lease.Holder, lease.Term)
+		e.held, e.term, e.expires = false, 0, time.Time{}
+	}
+	if !e.held && lease.Holder != "" && lease.Holder != e.holder && now.Before(lease.Expires) && lease.Holder != usurp {
+		log.Debugf("Lease on %v held by %v (term %v) until %v", e.resource, lease.Holder, lease.Term, lease.Expires)
+		return false, nil
+	}
+
+	term := lease.Term
+	if !e.held {
+		if lease.Holder != "" && lease.Holder == usurp && now.Before(lease.Expires) {
+			log.Infof("Lease on %v held by %v (term %v) until %v, taking over", e.resource, lease.Holder, lease.Term, lease.Expires)
+		} else if lease.Holder != "" && lease.Holder != e.holder {
+			log.Infof("Lease on %v held by %v (term %v) expired at %v, taking over", e.resource, lease.Holder, lease.Term, lease.Expires)
+		}
+		term++
+	}
+	expires := now.Add(e.ttl)
+	if err := e.writeLease(ctx, term, expires); err != nil {
+		return false, err
+	}
+
+	if !e.held {
+		// concurrent takeovers converge on the last writer
+		select {
+		case <-time.After(e.Settle):
+		case <-ctx.Done():
+			return false, errors.Wrapf(ctx.Err(), "Unable to read back lease on %v", e.resource)
+		}
+		lease, err := e.readLease(ctx)
+		if err != nil {
+			return false, err
+		}
+		if lease.Holder != e.holder || lease.Term != term {
+			log.Infof("Lost lease on %v to %v (term %v)", e.resource, lease.Holder, lease.Term)
+			return false, nil
+		}
+		log.Infof("Acquired lease on %v (term %v)", e.resource, term)
+	}
+	e.held, e.term, e.expires = true, term, expires
+	return true, nil
+}
+
+// Heartbeat records a successful reconciliation on the lease resource, it does nothing unless this node leads
+func (e *TagElector) Heartbeat(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.held {
+		return nil
+	}
+	_, err := e.ec2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{aws.String(e.resource)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(e.tags.Heartbeat), Value: aws.String(time.Now().UTC().Format(time.RFC3339Nano))},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write heartbeat on %v", e.resource)
+	}
+	return nil
+}
+
+// Term returns the term of the lease held by this node, 0 if it does not hold the lease
+func (e *TagElector) Term() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.term
+}
+
+// Expires returns when the lease held by this node expires, zero if it does not hold the lease
+func (e *TagElector) Expires() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.expires
+}
+
+// Resign expires the lease if this node holds it, so another node can take over without waiting for the ttl
+func (e *TagElector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.held {
+		return nil
+	}
+	e.held, e.expires = false, time.Time{}
+	// keep the term, it must keep increasing
+	if err := e.writeLease(ctx, e.term, time.Unix(0, 0)); err != nil {
+		return errors.Wrapf(err, "Unable to resign lease on %v", e.resource)
+	}
+	e.term = 0
+	log.Infof("Resigned lease on %v", e.resource)
+	return nil
+}
+
+// CheckTerm reads the lease and returns an error caused by ErrStaleTerm unless this node still holds it in term
+func (e *TagElector) CheckTerm(ctx context.Context, term int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lease, err := e.readLease(ctx)
+	if err != nil {
+		return err
+	}
+	if lease.Holder != e.holder || lease.Term != term || !time.Now().Before(lease.Expires) {
+		return errors.Wrapf(ErrStaleTerm, "lease on %v is held by %v in term %v until %v, not in term %v",
+			e.resource, lease.Holder, lease.Term, lease.Expires, term)
+	}
+	return nil
+}
+
+// ReadLease returns the lease as last written, e.g. to watch the heartbeat of the leader
+func (e *TagElector) ReadLease(ctx context.Context) (*Lease, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.resource == "" {
+		resource, err := e.findResource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		e.resource = resource
+	}
+	return e.readLease(ctx)
+}
+
+// LastHeartbeat returns the holder of the lease and its last heartbeat
+func (e *TagElector) LastHeartbeat(ctx context.Context, live []*discover.NatInstance) (string, time.Time, error) {
+	lease, err := e.ReadLease(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return lease.Holder, lease.Heartbeat, nil
+}
+
+// findResource returns the Routing Table of the cluster holding the lease of the highest term, so a Routing Table
+// added with a lower id does not elect a second leader, or the Routing Table with the lowest id if none holds a lease
+func (e *TagElector) findResource(ctx context.Context) (string, error) {
+	input := &ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name: aws.String(fmt.Sprintf("tag:%v", e.tags.ClusterId)),
+				Values: []*string{
+					aws.String(e.clusterId),
+				},
+			},
+			{
+				Name: aws.String("vpc-id"),
+				Values: []*string{
+					aws.String(e.vpcId),
+				},
+			},
+		},
+	}
+	var ids []string
+	err := e.ec2.DescribeRouteTablesPagesWithContext(ctx, input,
+		func(page *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+			for _, t := range page.RouteTables {
+				if t.RouteTableId != nil {
+					ids = append(ids, *t.RouteTableId)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to find RoutingTables for the lease")
+	}
+	if len(ids) == 0 {
+		return "", errors.Errorf("No RoutingTables with 'tag:%v=%v' to hold the lease", e.tags.ClusterId, e.clusterId)
+	}
+	sort.Strings(ids)
+
+	result, err := e.ec2.DescribeTagsWithContext(ctx, &ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: aws.StringSlice(ids),
+			},
+			{
+				Name:   aws.String("key"),
+				Values: []*string{aws.String(e.tags.LeaseTerm)},
+			},
+		},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to find the RoutingTable holding the lease")
+	}
+	resource, highest := ids[0], int64(-1)
+	for _, t := range result.Tags {
+		id := aws.StringValue(t.ResourceId)
+		term, err := strconv.ParseInt(aws.StringValue(t.Value), 10, 64)
+		if err != nil {
+			return "", errors.Errorf("Malformed %v=%v on %v", e.tags.LeaseTerm, aws.StringValue(t.Value), id)
+		}
+		if term > highest || term == highest && id < resource {
+			resource, highest = id, term
+		}
+	}
+	return resource, nil
+}
+
+// readLease returns the lease tags of the resource, malformed times are treated as unset.
+// A malformed term is an error: taking over from it could move the term backwards and past the fences.
+func (e *TagElector) readLease(ctx context.Context) (*Lease, error) {
+	result, err := e.ec2.DescribeTagsWithContext(ctx, &ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: []*string{aws.String(e.resource)},
+			},
+			{
+				Name: aws.String("key"),
+				Values: []*string{
+					aws.String(e.tags.LeaseHolder),
+					aws.String(e.tags.LeaseTerm),
+					aws.String(e.tags.LeaseExpires),
+					aws.String(e.tags.Heartbeat),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read lease on %v", e.resource)
+	}
+	lease := &Lease{}
+	for _, t := range result.Tags {
+		v := aws.StringValue(t.Value)
+		switch aws.StringValue(t.Key) {
+		case e.tags.LeaseHolder:
+			lease.Holder = v
+		case e.tags.LeaseTerm:
+			if lease.Term, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, errors.Errorf("Malformed %v=%v on %v", e.tags.LeaseTerm, v, e.resource)
+			}
+		case e.tags.LeaseExpires:
+			if lease.Expires, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				log.Warnf("Ignoring malformed %v=%v on %v", e.tags.LeaseExpires, v, e.resource)
+			}
+		case e.tags.Heartbeat:
+			if lease.Heartbeat, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				log.Warnf("Ignoring malformed %v=%v on %v", e.tags.Heartbeat, v, e.resource)
+			}
+		}
+	}
+	return lease, nil
+}
+
+func (e *TagElector) writeLease(ctx context.Context, term int64, expires time.Time) error {
+	_, err := e.ec2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{aws.String(e.resource)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(e.tags.LeaseHolder), Value: aws.String(e.holder)},
+			{Key: aws.String(e.tags.LeaseTerm), Value: aws.String(strconv.FormatInt(term, 10))},
+			{Key: aws.String(e.tags.LeaseExpires), Value: aws.String(expires.UTC().Format(time.RFC3339Nano))},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write lease on %v", e.resource)
+	}
+	return nil
+}
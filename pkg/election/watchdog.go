@@ -0,0 +1,129 @@
+package election
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+)
+
+// Heartbeats reads the heartbeat of the current leader
+type Heartbeats interface {
+	// LastHeartbeat returns the leader and the time of its last successful reconciliation,
+	// leader is blank if unknown and at is zero if the leader did not reconcile yet
+	LastHeartbeat(ctx context.Context, live []*discover.NatInstance) (leader string, at time.Time, err error)
+}
+
+// Usurper is an Elector which can take leadership from a leader which stopped reconciling
+type Usurper interface {
+	Elector
+	// Usurp takes leadership from leader, it returns false if another node takes over
+	Usurp(ctx context.Context, leader string, live []*discover.NatInstance) (bool, error)
+}
+
+// Takeover records leadership taken from a leader which stopped reconciling
+type Takeover struct {
+	From          string    `json:"from"`
+	LastHeartbeat time.Time `json:"lastHeartbeat,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+// Watchdog lets a passive node take over from a leader which is alive but stopped reconciling,
+// e.g. because its controller is hung.
+// The heartbeat is only compared with earlier reads by this node, so clocks do not need to be in sync.
+type Watchdog struct {
+	Usurper
+	heartbeats Heartbeats
+	holder     string
+	maxAge     time.Duration
+
+	mu        sync.Mutex
+	leader    string
+	heartbeat time.Time
+	// changed is when this node last saw the heartbeat change
+	changed  time.Time
+	takeover *Takeover
+}
+
+// NewWatchdog returns Watchdog for holder, which usurps e once the heartbeat of the leader did not change for maxAge
+func NewWatchdog(e Usurper, heartbeats Heartbeats, holder string, maxAge time.Duration) *Watchdog {
+	return &Watchdog{
+		Usurper:    e,
+		heartbeats: heartbeats,
+		holder:     holder,
+		maxAge:     maxAge,
+	}
+}
+
+// IsLeader returns true if this node leads or took over from a leader which stopped reconciling
+func (w *Watchdog) IsLeader(ctx context.Context, live []*discover.NatInstance) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.takeover = nil
+
+	leader, err := w.Usurper.IsLeader(ctx, live)
+	if err != nil || leader {
+		// watch again from scratch once this node is passive
+		w.leader = ""
+		return leader, err
+	}
+
+	id, at, err := w.heartbeats.LastHeartbeat(ctx, live)
+	if err != nil {
+		return false, errors.Wrap(err, "Unable to read heartbeat of the leader")
+	}
+	now := time.Now()
+	if id != w.leader || !at.Equal(w.heartbeat) {
+		w.leader, w.heartbeat, w.changed = id, at, now
+		return false, nil
+	}
+	if id == "" || id == w.holder || now.Sub(w.changed) < w.maxAge {
+		return false, nil
+	}
+
+	log.Warnf("Leader %v did not reconcile for %v (last heartbeat %v), taking over", id, now.Sub(w.changed).Round(time.Second), at)
+	leader, err = w.Usurp(ctx, id, live)
+	if err != nil || !leader {
+		return false, err
+	}
+	w.takeover = &Takeover{
+		From:          id,
+		LastHeartbeat: at,
+		At:            now,
+	}
+	return true, nil
+}
+
+// Takeover returns the Takeover made by the last call of IsLeader, nil if this node did not take over
+func (w *Watchdog) Takeover() *Takeover {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.takeover
+}
+
+// Term returns the term of the watched Elector, 0 if it keeps no terms
+func (w *Watchdog) Term() int64 {
+	if f, ok := w.Usurper.(Fencer); ok {
+		return f.Term()
+	}
+	return 0
+}
+
+// CheckTerm checks term with the watched Elector, it returns nil if it keeps no terms
+func (w *Watchdog) CheckTerm(ctx context.Context, term int64) error {
+	if f, ok := w.Usurper.(Fencer); ok {
+		return f.CheckTerm(ctx, term)
+	}
+	return nil
+}
+
+// Expires returns when the lease of the watched Elector expires, zero if it keeps no leases
+func (w *Watchdog) Expires() time.Time {
+	if l, ok := w.Usurper.(Leaser); ok {
+		return l.Expires()
+	}
+	return time.Time{}
+}
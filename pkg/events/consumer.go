@@ -0,0 +1,120 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// retryDelay is the time to wait before polling the queue again after an error
+const retryDelay = 5 * time.Second
+
+// maxMessages is the number of messages received at once, the most SQS allows
+const maxMessages = 10
+
+// Consumer receives EC2 state-change and route table change events from an SQS queue
+// and passes on the events which should trigger a reconciliation
+type Consumer struct {
+	sqs      sqsiface.SQSAPI
+	queueURL string
+	events   chan *Event
+	// ignored holds the role session names whose API calls do not trigger a reconciliation
+	ignored map[string]bool
+}
+
+// NewSQSConsumerFromSession returns Consumer for queueURL from session
+func NewSQSConsumerFromSession(session *session.Session, queueURL string, cfgs ...*aws.Config) (*Consumer, error) {
+	return NewSQSConsumer(sqs.New(session, cfgs...), queueURL)
+}
+
+// NewSQSConsumer returns Consumer for queueURL using sqs svc
+func NewSQSConsumer(svc sqsiface.SQSAPI, queueURL string) (*Consumer, error) {
+	if queueURL == "" {
+		return nil, errors.New("queue url can not be blank")
+	}
+	return &Consumer{
+		sqs:      svc,
+		queueURL: queueURL,
+		// buffer the relevant events of a receive
+		events:  make(chan *Event, maxMessages),
+		ignored: make(map[string]bool),
+	}, nil
+}
+
+// Ignore drops the API call events of the role session name, e.g. the routes updated by this node
+func (c *Consumer) Ignore(sessionName string) {
+	if sessionName != "" {
+		c.ignored[sessionName] = true
+	}
+}
+
+// Events returns a channel which receives the relevant events consumed
+func (c *Consumer) Events() <-chan *Event {
+	return c.events
+}
+
+// Run consumes the queue until ctx is done
+func (c *Consumer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := c.ReceiveOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("Error receiving events: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+}
+
+// ReceiveOnce long polls the queue once and deletes the received messages
+func (c *Consumer) ReceiveOnce(ctx context.Context) error {
+	out, err := c.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(c.queueURL),
+		MaxNumberOfMessages: aws.Int64(maxMessages),
+		WaitTimeSeconds:     aws.Int64(20),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to receive messages")
+	}
+
+	var relevant []*Event
+	for _, m := range out.Messages {
+		if m.Body != nil {
+			e, err := ParseEvent(*m.Body)
+			if err != nil {
+				log.Warnf("Dropping message %v: %v", aws.StringValue(m.MessageId), err)
+			} else if c.ignored[e.SessionName()] {
+				log.Debugf("Ignoring event of %v: %v", e.SessionName(), e)
+			} else if e.Relevant() {
+				log.Debugf("Received event: %v", e)
+				relevant = append(relevant, e)
+			}
+		}
+		// irrelevant and malformed messages are deleted as well, they would never become relevant
+		_, err := c.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(c.queueURL),
+			ReceiptHandle: m.ReceiptHandle,
+		})
+		if err != nil {
+			log.Warnf("Unable to delete message %v: %v", aws.StringValue(m.MessageId), err)
+		}
+	}
+
+	for _, e := range relevant {
+		select {
+		case c.events <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
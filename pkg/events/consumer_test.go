@@ -0,0 +1,134 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/so0k/aws-nat-router/pkg/events"
+)
+
+const (
+	stateChange  = `{"source":"aws.ec2","detail-type":"EC2 Instance State-change Notification","detail":{"instance-id":"i-1","state":"stopping"}}`
+	replaceRoute = `{"source":"aws.ec2","detail-type":"AWS API Call via CloudTrail","detail":{"eventSource":"ec2.amazonaws.com","eventName":"ReplaceRoute"}}`
+	ownRoute     = `{"source":"aws.ec2","detail-type":"AWS API Call via CloudTrail","detail":{"eventSource":"ec2.amazonaws.com","eventName":"ReplaceRoute","userIdentity":{"principalId":"AROAEXAMPLE:i-a","arn":"arn:aws:sts::123456789012:assumed-role/nat-router/i-a"}}}`
+	runInstances = `{"source":"aws.ec2","detail-type":"AWS API Call via CloudTrail","detail":{"eventSource":"ec2.amazonaws.com","eventName":"RunInstances"}}`
+)
+
+// queue is an in-memory stand-in for an SQS queue
+type queue struct {
+	sqsiface.SQSAPI
+	bodies  []string
+	deleted int
+}
+
+func (q *queue) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if len(q.bodies) == 0 {
+		// long poll an empty queue
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	out := &sqs.ReceiveMessageOutput{}
+	for _, b := range q.bodies {
+		out.Messages = append(out.Messages, &sqs.Message{
+			Body:          aws.String(b),
+			ReceiptHandle: aws.String(b),
+		})
+	}
+	q.bodies = nil
+	return out, nil
+}
+
+func (q *queue) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	q.deleted++
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestRelevant(t *testing.T) {
+	for body, want := range map[string]bool{
+		stateChange:  true,
+		replaceRoute: true,
+		runInstances: false,
+	} {
+		e, err := events.ParseEvent(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Relevant() != want {
+			t.Errorf("Relevant() = %v for %v", !want, body)
+		}
+	}
+}
+
+func TestReceiveOnce(t *testing.T) {
+	q := &queue{bodies: []string{runInstances, "not json"}}
+	c, err := events.NewSQSConsumer(q, "http://localhost:9324/queue/nat-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ReceiveOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Events():
+		t.Error("irrelevant events triggered a reconciliation")
+	default:
+	}
+
+	q.bodies = []string{stateChange, replaceRoute}
+	if err := c.ReceiveOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Events():
+	default:
+		t.Error("relevant events did not trigger a reconciliation")
+	}
+	if q.deleted != 4 {
+		t.Errorf("deleted %v messages, want 4", q.deleted)
+	}
+}
+
+func TestReceiveOnceIgnoresOwnCalls(t *testing.T) {
+	q := &queue{bodies: []string{ownRoute}}
+	c, err := events.NewSQSConsumer(q, "http://localhost:9324/queue/nat-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Ignore("i-a")
+	if err := c.ReceiveOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Events():
+		t.Error("a route updated by this node triggered a reconciliation")
+	default:
+	}
+	if q.deleted != 1 {
+		t.Errorf("deleted %v messages, want 1", q.deleted)
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	c, err := events.NewSQSConsumer(&queue{}, "http://localhost:9324/queue/nat-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Run did not stop")
+	}
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Event is the subset of an EventBridge event the router reacts to
+// ref - https://docs.aws.amazon.com/eventbridge/latest/userguide/aws-events.html
+type Event struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+	Account    string `json:"account"`
+	Region     string `json:"region"`
+	Detail     Detail `json:"detail"`
+}
+
+// Detail holds the fields of EC2 state-change and CloudTrail API call events
+type Detail struct {
+	// set for EC2 Instance State-change Notification
+	InstanceId string `json:"instance-id"`
+	State      string `json:"state"`
+	// set for AWS API Call via CloudTrail
+	EventSource       string            `json:"eventSource"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      UserIdentity      `json:"userIdentity"`
+	RequestParameters RequestParameters `json:"requestParameters"`
+}
+
+// RequestParameters holds the parameters of an API call which identify the Routing Table it changed,
+// some calls such as DisassociateRouteTable only identify an association
+type RequestParameters struct {
+	RouteTableId string `json:"routeTableId"`
+	VpcId        string `json:"vpcId"`
+}
+
+// UserIdentity identifies the caller of an API call
+type UserIdentity struct {
+	PrincipalId string `json:"principalId"`
+	Arn         string `json:"arn"`
+}
+
+const (
+	stateChangeDetailType = "EC2 Instance State-change Notification"
+	apiCallDetailType     = "AWS API Call via CloudTrail"
+)
+
+// routeTableEvents lists the EC2 API calls which change routing tables
+var routeTableEvents = map[string]bool{
+	"CreateRoute":                  true,
+	"ReplaceRoute":                 true,
+	"DeleteRoute":                  true,
+	"CreateRouteTable":             true,
+	"DeleteRouteTable":             true,
+	"AssociateRouteTable":          true,
+	"DisassociateRouteTable":       true,
+	"ReplaceRouteTableAssociation": true,
+}
+
+// ParseEvent parses the body of an EventBridge event
+func ParseEvent(body string) (*Event, error) {
+	e := &Event{}
+	if err := json.Unmarshal([]byte(body), e); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse event")
+	}
+	return e, nil
+}
+
+// Relevant returns true if the event should trigger a reconciliation
+func (e *Event) Relevant() bool {
+	if e.Source != "aws.ec2" {
+		return false
+	}
+	switch e.DetailType {
+	case stateChangeDetailType:
+		return true
+	case apiCallDetailType:
+		return e.Detail.EventSource == "ec2.amazonaws.com" && routeTableEvents[e.Detail.EventName]
+	}
+	return false
+}
+
+// SessionName returns the role session name of the caller of an API call, blank if it did not assume a role.
+// Instance profiles use the InstanceId as role session name.
+func (e *Event) SessionName() string {
+	id := e.Detail.UserIdentity
+	if strings.Contains(id.Arn, ":assumed-role/") {
+		return id.Arn[strings.LastIndex(id.Arn, "/")+1:]
+	}
+	if i := strings.LastIndex(id.PrincipalId, ":"); i >= 0 {
+		return id.PrincipalId[i+1:]
+	}
+	return ""
+}
+
+func (e *Event) String() string {
+	if e.DetailType == stateChangeDetailType {
+		return e.Detail.InstanceId + " " + e.Detail.State
+	}
+	return e.Detail.EventName
+}
@@ -0,0 +1,130 @@
+package fake
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+)
+
+// AutoScaling is an in-memory autoscalingiface.AutoScalingAPI holding Auto Scaling groups and their tags.
+// Methods not used by aws-nat-router are not implemented and panic when called.
+type AutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+
+	// PageSize limits the number of resources per page of Describe*Pages, 0 returns a single page
+	PageSize int
+
+	mu     sync.Mutex
+	groups []*autoscaling.Group
+	calls  map[string]int
+}
+
+// NewAutoScaling returns an empty AutoScaling
+func NewAutoScaling() *AutoScaling {
+	return &AutoScaling{
+		calls: make(map[string]int),
+	}
+}
+
+// AddGroup adds an Auto Scaling group with tags, instances maps the id of each member to its lifecycle state
+func (f *AutoScaling) AddGroup(name string, tags map[string]string, instances map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g := &autoscaling.Group{
+		AutoScalingGroupName: aws.String(name),
+	}
+	for key, value := range tags {
+		g.Tags = append(g.Tags, &autoscaling.TagDescription{
+			Key:          aws.String(key),
+			Value:        aws.String(value),
+			ResourceId:   aws.String(name),
+			ResourceType: aws.String("auto-scaling-group"),
+		})
+	}
+	for id, state := range instances {
+		g.Instances = append(g.Instances, &autoscaling.Instance{
+			InstanceId:     aws.String(id),
+			LifecycleState: aws.String(state),
+		})
+	}
+	f.groups = append(f.groups, g)
+}
+
+// Calls returns the number of calls of an API operation
+func (f *AutoScaling) Calls(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// DescribeAutoScalingGroupsPagesWithContext calls fn with pages of the named Auto Scaling groups, or all groups
+// if no names are given
+func (f *AutoScaling) DescribeAutoScalingGroupsPagesWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool, opts ...request.Option) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	f.mu.Lock()
+	f.calls["DescribeAutoScalingGroups"]++
+	var groups []*autoscaling.Group
+	for _, g := range f.groups {
+		if len(input.AutoScalingGroupNames) > 0 && !contains(input.AutoScalingGroupNames, aws.StringValue(g.AutoScalingGroupName)) {
+			continue
+		}
+		c := *g
+		groups = append(groups, &c)
+	}
+	f.mu.Unlock()
+
+	for _, p := range pages(len(groups), f.PageSize) {
+		last := p[1] == len(groups)
+		if !fn(&autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: groups[p[0]:p[1]]}, last) || last {
+			break
+		}
+	}
+	return nil
+}
+
+// DescribeTagsPagesWithContext calls fn with pages of the tags of Auto Scaling groups matching the key and value filters
+func (f *AutoScaling) DescribeTagsPagesWithContext(ctx aws.Context, input *autoscaling.DescribeTagsInput, fn func(*autoscaling.DescribeTagsOutput, bool) bool, opts ...request.Option) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	f.mu.Lock()
+	f.calls["DescribeTags"]++
+	var tags []*autoscaling.TagDescription
+	for _, g := range f.groups {
+		for _, t := range g.Tags {
+			ok := true
+			for _, filter := range input.Filters {
+				switch aws.StringValue(filter.Name) {
+				case "key":
+					ok = ok && contains(filter.Values, aws.StringValue(t.Key))
+				case "value":
+					ok = ok && contains(filter.Values, aws.StringValue(t.Value))
+				case "auto-scaling-group":
+					ok = ok && contains(filter.Values, aws.StringValue(t.ResourceId))
+				default:
+					f.mu.Unlock()
+					return awserr.New("ValidationError", "fake AutoScaling does not support filter "+aws.StringValue(filter.Name), nil)
+				}
+			}
+			if ok {
+				c := *t
+				tags = append(tags, &c)
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	for _, p := range pages(len(tags), f.PageSize) {
+		last := p[1] == len(tags)
+		if !fn(&autoscaling.DescribeTagsOutput{Tags: tags[p[0]:p[1]]}, last) || last {
+			break
+		}
+	}
+	return nil
+}
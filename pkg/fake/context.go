@@ -0,0 +1,121 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// wait returns the error of a request cancelled through ctx, blocking until ctx is done while op hangs
+func (f *EC2) wait(ctx aws.Context, op string) error {
+	f.mu.Lock()
+	hang := f.hangs[op]
+	if hang {
+		f.calls[op]++
+	}
+	f.mu.Unlock()
+	if hang {
+		<-ctx.Done()
+	}
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+// DescribeInstancesWithContext is DescribeInstances with a context
+func (f *EC2) DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	if err := f.wait(ctx, "DescribeInstances"); err != nil {
+		return nil, err
+	}
+	return f.DescribeInstances(input)
+}
+
+// DescribeInstancesPagesWithContext is DescribeInstancesPages with a context
+func (f *EC2) DescribeInstancesPagesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	if err := f.wait(ctx, "DescribeInstances"); err != nil {
+		return err
+	}
+	return f.DescribeInstancesPages(input, fn)
+}
+
+// DescribeRouteTablesWithContext is DescribeRouteTables with a context
+func (f *EC2) DescribeRouteTablesWithContext(ctx aws.Context, input *ec2.DescribeRouteTablesInput, opts ...request.Option) (*ec2.DescribeRouteTablesOutput, error) {
+	if err := f.wait(ctx, "DescribeRouteTables"); err != nil {
+		return nil, err
+	}
+	return f.DescribeRouteTables(input)
+}
+
+// DescribeRouteTablesPagesWithContext is DescribeRouteTablesPages with a context
+func (f *EC2) DescribeRouteTablesPagesWithContext(ctx aws.Context, input *ec2.DescribeRouteTablesInput, fn func(*ec2.DescribeRouteTablesOutput, bool) bool, opts ...request.Option) error {
+	if err := f.wait(ctx, "DescribeRouteTables"); err != nil {
+		return err
+	}
+	return f.DescribeRouteTablesPages(input, fn)
+}
+
+// DescribeSubnetsWithContext is DescribeSubnets with a context
+func (f *EC2) DescribeSubnetsWithContext(ctx aws.Context, input *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	if err := f.wait(ctx, "DescribeSubnets"); err != nil {
+		return nil, err
+	}
+	return f.DescribeSubnets(input)
+}
+
+// DescribeAvailabilityZonesWithContext is DescribeAvailabilityZones with a context
+func (f *EC2) DescribeAvailabilityZonesWithContext(ctx aws.Context, input *ec2.DescribeAvailabilityZonesInput, opts ...request.Option) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	if err := f.wait(ctx, "DescribeAvailabilityZones"); err != nil {
+		return nil, err
+	}
+	return f.DescribeAvailabilityZones(input)
+}
+
+// ReplaceRouteWithContext is ReplaceRoute with a context
+func (f *EC2) ReplaceRouteWithContext(ctx aws.Context, input *ec2.ReplaceRouteInput, opts ...request.Option) (*ec2.ReplaceRouteOutput, error) {
+	if err := f.wait(ctx, "ReplaceRoute"); err != nil {
+		return nil, err
+	}
+	return f.ReplaceRoute(input)
+}
+
+// CreateRouteWithContext is CreateRoute with a context
+func (f *EC2) CreateRouteWithContext(ctx aws.Context, input *ec2.CreateRouteInput, opts ...request.Option) (*ec2.CreateRouteOutput, error) {
+	if err := f.wait(ctx, "CreateRoute"); err != nil {
+		return nil, err
+	}
+	return f.CreateRoute(input)
+}
+
+// ModifyInstanceAttributeWithContext is ModifyInstanceAttribute with a context
+func (f *EC2) ModifyInstanceAttributeWithContext(ctx aws.Context, input *ec2.ModifyInstanceAttributeInput, opts ...request.Option) (*ec2.ModifyInstanceAttributeOutput, error) {
+	if err := f.wait(ctx, "ModifyInstanceAttribute"); err != nil {
+		return nil, err
+	}
+	return f.ModifyInstanceAttribute(input)
+}
+
+// CreateTagsWithContext is CreateTags with a context
+func (f *EC2) CreateTagsWithContext(ctx aws.Context, input *ec2.CreateTagsInput, opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	if err := f.wait(ctx, "CreateTags"); err != nil {
+		return nil, err
+	}
+	return f.CreateTags(input)
+}
+
+// DeleteTagsWithContext is DeleteTags with a context
+func (f *EC2) DeleteTagsWithContext(ctx aws.Context, input *ec2.DeleteTagsInput, opts ...request.Option) (*ec2.DeleteTagsOutput, error) {
+	if err := f.wait(ctx, "DeleteTags"); err != nil {
+		return nil, err
+	}
+	return f.DeleteTags(input)
+}
+
+// DescribeTagsWithContext is DescribeTags with a context
+func (f *EC2) DescribeTagsWithContext(ctx aws.Context, input *ec2.DescribeTagsInput, opts ...request.Option) (*ec2.DescribeTagsOutput, error) {
+	if err := f.wait(ctx, "DescribeTags"); err != nil {
+		return nil, err
+	}
+	return f.DescribeTags(input)
+}
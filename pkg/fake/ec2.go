@@ -0,0 +1,818 @@
+// Package fake provides in-memory stand-ins for the AWS APIs used by aws-nat-router, for tests
+package fake
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// stateCodes holds the code of each instance state
+var stateCodes = map[string]int64{
+	ec2.InstanceStateNamePending:      0,
+	ec2.InstanceStateNameRunning:      16,
+	ec2.InstanceStateNameShuttingDown: 32,
+	ec2.InstanceStateNameTerminated:   48,
+	ec2.InstanceStateNameStopping:     64,
+	ec2.InstanceStateNameStopped:      80,
+}
+
+// Instance describes an instance to add to EC2
+type Instance struct {
+	Id        string
+	VpcId     string
+	Zone      string
+	PrivateIP string
+	PublicIP  string
+	// State defaults to running
+	State      string
+	LaunchTime time.Time
+	// Lifecycle is spot for Spot Instances, blank for on-demand
+	Lifecycle string
+	Tags      map[string]string
+}
+
+// RouteTable describes a route table to add to EC2
+type RouteTable struct {
+	Id      string
+	VpcId   string
+	Subnets []string
+	// Egress is the instance the 0.0.0.0/0 route points to, blank for no such route
+	Egress string
+	Tags   map[string]string
+}
+
+// EC2 is an in-memory ec2iface.EC2API holding instances, subnets and route tables.
+// Methods not used by aws-nat-router are not implemented and panic when called.
+//
+// Failures can be injected per API operation with Fail, e.g. to test failover.
+type EC2 struct {
+	ec2iface.EC2API
+
+	// PageSize limits the number of resources per page of Describe*Pages, 0 returns a single page
+	PageSize int
+
+	mu          sync.Mutex
+	zones       []*ec2.AvailabilityZone
+	instances   []*ec2.Instance
+	subnets     []*ec2.Subnet
+	routeTables []*ec2.RouteTable
+	failures    map[string]*failure
+	hangs       map[string]bool
+	calls       map[string]int
+}
+
+// failure is returned by the next n calls of an operation, n < 0 fails every call
+type failure struct {
+	n   int
+	err error
+}
+
+// NewEC2 returns an empty EC2
+func NewEC2() *EC2 {
+	return &EC2{
+		failures: make(map[string]*failure),
+		hangs:    make(map[string]bool),
+		calls:    make(map[string]int),
+	}
+}
+
+// AddZone adds an AvailabilityZone with its AZ ID
+func (f *EC2) AddZone(name, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.zones = append(f.zones, &ec2.AvailabilityZone{
+		ZoneName: aws.String(name),
+		ZoneId:   aws.String(id),
+		State:    aws.String("available"),
+	})
+}
+
+// AddInstance adds an instance, source/destination checking is enabled as for new instances in AWS
+func (f *EC2) AddInstance(i Instance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i.State == "" {
+		i.State = ec2.InstanceStateNameRunning
+	}
+	in := &ec2.Instance{
+		InstanceId:      aws.String(i.Id),
+		VpcId:           aws.String(i.VpcId),
+		LaunchTime:      aws.Time(i.LaunchTime),
+		SourceDestCheck: aws.Bool(true),
+		Placement: &ec2.Placement{
+			AvailabilityZone: aws.String(i.Zone),
+		},
+		Tags: newTags(i.Tags),
+	}
+	if i.PrivateIP != "" {
+		in.PrivateIpAddress = aws.String(i.PrivateIP)
+	}
+	if i.PublicIP != "" {
+		in.PublicIpAddress = aws.String(i.PublicIP)
+	}
+	if i.Lifecycle != "" {
+		in.InstanceLifecycle = aws.String(i.Lifecycle)
+	}
+	setState(in, i.State)
+	f.instances = append(f.instances, in)
+}
+
+// AddRawInstance adds i as is, e.g. an instance missing attributes which AWS usually sets
+func (f *EC2) AddRawInstance(i *ec2.Instance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances = append(f.instances, copyInstance(i))
+}
+
+// AddSubnet adds a subnet in zone
+func (f *EC2) AddSubnet(id, vpcId, zone string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subnets = append(f.subnets, &ec2.Subnet{
+		SubnetId:         aws.String(id),
+		VpcId:            aws.String(vpcId),
+		AvailabilityZone: aws.String(zone),
+	})
+}
+
+// AddRouteTable adds a route table associated with its subnets
+func (f *EC2) AddRouteTable(rt RouteTable) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &ec2.RouteTable{
+		RouteTableId: aws.String(rt.Id),
+		VpcId:        aws.String(rt.VpcId),
+		Tags:         newTags(rt.Tags),
+	}
+	for n, s := range rt.Subnets {
+		t.Associations = append(t.Associations, &ec2.RouteTableAssociation{
+			RouteTableAssociationId: aws.String(fmt.Sprintf("rtbassoc-%v-%v", rt.Id, n)),
+			RouteTableId:            aws.String(rt.Id),
+			SubnetId:                aws.String(s),
+		})
+	}
+	if rt.Egress != "" {
+		t.Routes = append(t.Routes, &ec2.Route{
+			DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			InstanceId:           aws.String(rt.Egress),
+			Origin:               aws.String("CreateRoute"),
+		})
+	}
+	f.routeTables = append(f.routeTables, t)
+}
+
+// SetInstanceState changes the state of an instance, e.g. to stopping
+func (f *EC2) SetInstanceState(id, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i := f.instance(id); i != nil {
+		setState(i, state)
+	}
+}
+
+// Egress returns the instance the 0.0.0.0/0 route of a route table points to
+func (f *EC2) Egress(routeTableId string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := f.routeTable(routeTableId)
+	if t == nil {
+		return ""
+	}
+	if r := route(t, "0.0.0.0/0"); r != nil {
+		return aws.StringValue(r.InstanceId)
+	}
+	return ""
+}
+
+// SourceDestCheck returns true if source/destination checking is enabled for an instance
+func (f *EC2) SourceDestCheck(instanceId string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i := f.instance(instanceId); i != nil {
+		return aws.BoolValue(i.SourceDestCheck)
+	}
+	return false
+}
+
+// Fail makes the next n calls of an API operation such as "ReplaceRoute" return err,
+// n < 0 fails every call until Recover is called
+func (f *EC2) Fail(op string, n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[op] = &failure{n: n, err: err}
+}
+
+// Hang makes calls of an API operation through its WithContext variant block until their context is done,
+// e.g. to test timeouts, until Recover is called
+func (f *EC2) Hang(op string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hangs[op] = true
+}
+
+// Recover stops injected failures and hangs of an API operation
+func (f *EC2) Recover(op string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.failures, op)
+	delete(f.hangs, op)
+}
+
+// Calls returns the number of calls of an API operation, including failed calls
+func (f *EC2) Calls(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// call records a call of op and returns the injected failure, if any
+func (f *EC2) call(op string) error {
+	f.calls[op]++
+	fl, ok := f.failures[op]
+	if !ok {
+		return nil
+	}
+	if fl.n > 0 {
+		fl.n--
+		if fl.n == 0 {
+			delete(f.failures, op)
+		}
+	}
+	return fl.err
+}
+
+// DescribeInstances returns the instances matching the filters in a single reservation per instance
+func (f *EC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	var out *ec2.DescribeInstancesOutput
+	err := f.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		if out == nil {
+			out = page
+		} else {
+			out.Reservations = append(out.Reservations, page.Reservations...)
+		}
+		return true
+	})
+	return out, err
+}
+
+// DescribeInstancesPages calls fn with pages of instances matching the filters
+func (f *EC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	f.mu.Lock()
+	if err := f.call("DescribeInstances"); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	for _, id := range input.InstanceIds {
+		if f.instance(aws.StringValue(id)) == nil {
+			f.mu.Unlock()
+			return awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%v' does not exist", aws.StringValue(id)), nil)
+		}
+	}
+	var rs []*ec2.Reservation
+	for _, i := range f.instances {
+		if len(input.InstanceIds) > 0 && !contains(input.InstanceIds, aws.StringValue(i.InstanceId)) {
+			continue
+		}
+		ok, err := matchFilters(input.Filters, i.Tags, func(name string) (string, bool) {
+			switch name {
+			case "vpc-id":
+				return aws.StringValue(i.VpcId), true
+			case "instance-id":
+				return aws.StringValue(i.InstanceId), true
+			case "instance-state-name":
+				return stateName(i), true
+			case "availability-zone":
+				if i.Placement == nil {
+					return "", true
+				}
+				return aws.StringValue(i.Placement.AvailabilityZone), true
+			}
+			return "", false
+		})
+		if err != nil {
+			f.mu.Unlock()
+			return err
+		}
+		if ok {
+			rs = append(rs, &ec2.Reservation{Instances: []*ec2.Instance{copyInstance(i)}})
+		}
+	}
+	f.mu.Unlock()
+
+	for _, p := range pages(len(rs), f.PageSize) {
+		last := p[1] == len(rs)
+		if !fn(&ec2.DescribeInstancesOutput{Reservations: rs[p[0]:p[1]]}, last) || last {
+			break
+		}
+	}
+	return nil
+}
+
+// DescribeRouteTables returns the route tables matching the filters
+func (f *EC2) DescribeRouteTables(input *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	out := &ec2.DescribeRouteTablesOutput{}
+	err := f.DescribeRouteTablesPages(input, func(page *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+		out.RouteTables = append(out.RouteTables, page.RouteTables...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DescribeRouteTablesPages calls fn with pages of route tables matching the filters
+func (f *EC2) DescribeRouteTablesPages(input *ec2.DescribeRouteTablesInput, fn func(*ec2.DescribeRouteTablesOutput, bool) bool) error {
+	f.mu.Lock()
+	if err := f.call("DescribeRouteTables"); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	var ts []*ec2.RouteTable
+	for _, t := range f.routeTables {
+		if len(input.RouteTableIds) > 0 && !contains(input.RouteTableIds, aws.StringValue(t.RouteTableId)) {
+			continue
+		}
+		ok, err := matchFilters(input.Filters, t.Tags, func(name string) (string, bool) {
+			switch name {
+			case "vpc-id":
+				return aws.StringValue(t.VpcId), true
+			case "route-table-id":
+				return aws.StringValue(t.RouteTableId), true
+			}
+			return "", false
+		})
+		if err != nil {
+			f.mu.Unlock()
+			return err
+		}
+		if ok {
+			ts = append(ts, f.copyRouteTable(t))
+		}
+	}
+	f.mu.Unlock()
+
+	for _, p := range pages(len(ts), f.PageSize) {
+		last := p[1] == len(ts)
+		if !fn(&ec2.DescribeRouteTablesOutput{RouteTables: ts[p[0]:p[1]]}, last) || last {
+			break
+		}
+	}
+	return nil
+}
+
+// DescribeSubnets returns the subnets matching the filters
+func (f *EC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeSubnets"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeSubnetsOutput{}
+	for _, s := range f.subnets {
+		if len(input.SubnetIds) > 0 && !contains(input.SubnetIds, aws.StringValue(s.SubnetId)) {
+			continue
+		}
+		ok, err := matchFilters(input.Filters, s.Tags, func(name string) (string, bool) {
+			switch name {
+			case "vpc-id":
+				return aws.StringValue(s.VpcId), true
+			case "subnet-id":
+				return aws.StringValue(s.SubnetId), true
+			case "availability-zone":
+				return aws.StringValue(s.AvailabilityZone), true
+			}
+			return "", false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := *s
+			for _, z := range f.zones {
+				if aws.StringValue(z.ZoneName) == aws.StringValue(s.AvailabilityZone) {
+					c.AvailabilityZoneId = z.ZoneId
+				}
+			}
+			out.Subnets = append(out.Subnets, &c)
+		}
+	}
+	return out, nil
+}
+
+// DescribeAvailabilityZones returns the zones added with AddZone, filters are not supported
+func (f *EC2) DescribeAvailabilityZones(input *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeAvailabilityZones"); err != nil {
+		return nil, err
+	}
+	if len(input.Filters) > 0 {
+		return nil, awserr.New("InvalidParameterValue", "fake EC2 does not support filtering AvailabilityZones", nil)
+	}
+	out := &ec2.DescribeAvailabilityZonesOutput{}
+	for _, z := range f.zones {
+		c := *z
+		out.AvailabilityZones = append(out.AvailabilityZones, &c)
+	}
+	return out, nil
+}
+
+// ReplaceRoute points an existing route at an instance
+func (f *EC2) ReplaceRoute(input *ec2.ReplaceRouteInput) (*ec2.ReplaceRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ReplaceRoute"); err != nil {
+		return nil, err
+	}
+	t, err := f.routeTarget(input.RouteTableId, input.InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	dst := aws.StringValue(input.DestinationCidrBlock)
+	r := route(t, dst)
+	if r == nil {
+		return nil, awserr.New("InvalidParameterValue", fmt.Sprintf("There is no route defined for '%v' in the route table", dst), nil)
+	}
+	*r = ec2.Route{
+		DestinationCidrBlock: aws.String(dst),
+		InstanceId:           aws.String(aws.StringValue(input.InstanceId)),
+		Origin:               aws.String("CreateRoute"),
+	}
+	return &ec2.ReplaceRouteOutput{}, nil
+}
+
+// CreateRoute adds a route through an instance
+func (f *EC2) CreateRoute(input *ec2.CreateRouteInput) (*ec2.CreateRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateRoute"); err != nil {
+		return nil, err
+	}
+	t, err := f.routeTarget(input.RouteTableId, input.InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	dst := aws.StringValue(input.DestinationCidrBlock)
+	if route(t, dst) != nil {
+		return nil, awserr.New("RouteAlreadyExists", fmt.Sprintf("The route identified by %v already exists", dst), nil)
+	}
+	t.Routes = append(t.Routes, &ec2.Route{
+		DestinationCidrBlock: aws.String(dst),
+		InstanceId:           aws.String(aws.StringValue(input.InstanceId)),
+		Origin:               aws.String("CreateRoute"),
+	})
+	return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+}
+
+// ModifyInstanceAttribute changes the source/destination checking of an instance, other attributes are not supported
+func (f *EC2) ModifyInstanceAttribute(input *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ModifyInstanceAttribute"); err != nil {
+		return nil, err
+	}
+	i := f.instance(aws.StringValue(input.InstanceId))
+	if i == nil {
+		return nil, instanceNotFound(input.InstanceId)
+	}
+	if input.SourceDestCheck == nil {
+		return nil, awserr.New("InvalidParameterCombination", "fake EC2 only supports modifying SourceDestCheck", nil)
+	}
+	i.SourceDestCheck = aws.Bool(aws.BoolValue(input.SourceDestCheck.Value))
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+// CreateTags adds or overwrites tags of instances, subnets and route tables
+func (f *EC2) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateTags"); err != nil {
+		return nil, err
+	}
+	for _, id := range input.Resources {
+		tags, err := f.tags(aws.StringValue(id))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range input.Tags {
+			*tags = setTag(*tags, aws.StringValue(t.Key), aws.StringValue(t.Value))
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// DeleteTags removes tags of instances, subnets and route tables by key
+func (f *EC2) DeleteTags(input *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteTags"); err != nil {
+		return nil, err
+	}
+	for _, id := range input.Resources {
+		tags, err := f.tags(aws.StringValue(id))
+		if err != nil {
+			return nil, err
+		}
+		var kept []*ec2.Tag
+		for _, t := range *tags {
+			deleted := false
+			for _, d := range input.Tags {
+				if aws.StringValue(d.Key) == aws.StringValue(t.Key) {
+					deleted = true
+				}
+			}
+			if !deleted {
+				kept = append(kept, t)
+			}
+		}
+		*tags = kept
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// DescribeTags returns the tags of instances, subnets and route tables matching the filters
+func (f *EC2) DescribeTags(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeTags"); err != nil {
+		return nil, err
+	}
+	type resource struct {
+		id, kind string
+		tags     []*ec2.Tag
+	}
+	var resources []resource
+	for _, i := range f.instances {
+		resources = append(resources, resource{aws.StringValue(i.InstanceId), "instance", i.Tags})
+	}
+	for _, s := range f.subnets {
+		resources = append(resources, resource{aws.StringValue(s.SubnetId), "subnet", s.Tags})
+	}
+	for _, t := range f.routeTables {
+		resources = append(resources, resource{aws.StringValue(t.RouteTableId), "route-table", t.Tags})
+	}
+
+	out := &ec2.DescribeTagsOutput{}
+	for _, r := range resources {
+		for _, t := range r.tags {
+			ok, err := matchFilters(input.Filters, nil, func(name string) (string, bool) {
+				switch name {
+				case "resource-id":
+					return r.id, true
+				case "resource-type":
+					return r.kind, true
+				case "key":
+					return aws.StringValue(t.Key), true
+				case "value":
+					return aws.StringValue(t.Value), true
+				}
+				return "", false
+			})
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out.Tags = append(out.Tags, &ec2.TagDescription{
+					ResourceId:   aws.String(r.id),
+					ResourceType: aws.String(r.kind),
+					Key:          aws.String(aws.StringValue(t.Key)),
+					Value:        aws.String(aws.StringValue(t.Value)),
+				})
+			}
+		}
+	}
+	return out, nil
+}
+
+// Tag returns the value of a tag of an instance, subnet or route table
+func (f *EC2) Tag(resourceId, key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tags, err := f.tags(resourceId)
+	if err != nil {
+		return ""
+	}
+	for _, t := range *tags {
+		if aws.StringValue(t.Key) == key {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return ""
+}
+
+// tags returns the tags of an instance, subnet or route table
+func (f *EC2) tags(id string) (*[]*ec2.Tag, error) {
+	if i := f.instance(id); i != nil {
+		return &i.Tags, nil
+	}
+	if t := f.routeTable(id); t != nil {
+		return &t.Tags, nil
+	}
+	for _, s := range f.subnets {
+		if aws.StringValue(s.SubnetId) == id {
+			return &s.Tags, nil
+		}
+	}
+	return nil, awserr.New("InvalidID", fmt.Sprintf("The ID '%v' is not valid", id), nil)
+}
+
+// routeTarget returns the route table of a route after checking the instance exists
+func (f *EC2) routeTarget(routeTableId, instanceId *string) (*ec2.RouteTable, error) {
+	t := f.routeTable(aws.StringValue(routeTableId))
+	if t == nil {
+		return nil, awserr.New("InvalidRouteTableID.NotFound", fmt.Sprintf("The routeTable ID '%v' does not exist", aws.StringValue(routeTableId)), nil)
+	}
+	if f.instance(aws.StringValue(instanceId)) == nil {
+		return nil, instanceNotFound(instanceId)
+	}
+	return t, nil
+}
+
+func (f *EC2) instance(id string) *ec2.Instance {
+	for _, i := range f.instances {
+		if aws.StringValue(i.InstanceId) == id {
+			return i
+		}
+	}
+	return nil
+}
+
+func (f *EC2) routeTable(id string) *ec2.RouteTable {
+	for _, t := range f.routeTables {
+		if aws.StringValue(t.RouteTableId) == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// copyRouteTable copies a route table, routes through instances which are not running are blackholes
+func (f *EC2) copyRouteTable(t *ec2.RouteTable) *ec2.RouteTable {
+	c := *t
+	c.Tags = copyTags(t.Tags)
+	c.Associations = nil
+	for _, a := range t.Associations {
+		ac := *a
+		c.Associations = append(c.Associations, &ac)
+	}
+	c.Routes = nil
+	for _, r := range t.Routes {
+		rc := *r
+		rc.State = aws.String(ec2.RouteStateActive)
+		if r.InstanceId != nil {
+			i := f.instance(*r.InstanceId)
+			if i == nil || stateName(i) != ec2.InstanceStateNameRunning {
+				rc.State = aws.String(ec2.RouteStateBlackhole)
+			}
+		}
+		c.Routes = append(c.Routes, &rc)
+	}
+	return &c
+}
+
+func copyInstance(i *ec2.Instance) *ec2.Instance {
+	c := *i
+	c.Tags = copyTags(i.Tags)
+	if i.State != nil {
+		state := *i.State
+		c.State = &state
+	}
+	if i.Placement != nil {
+		placement := *i.Placement
+		c.Placement = &placement
+	}
+	if i.SourceDestCheck != nil {
+		c.SourceDestCheck = aws.Bool(*i.SourceDestCheck)
+	}
+	return &c
+}
+
+// stateName returns the state of i, blank if it has none
+func stateName(i *ec2.Instance) string {
+	if i.State == nil {
+		return ""
+	}
+	return aws.StringValue(i.State.Name)
+}
+
+func copyTags(tags []*ec2.Tag) []*ec2.Tag {
+	var c []*ec2.Tag
+	for _, t := range tags {
+		tc := *t
+		c = append(c, &tc)
+	}
+	return c
+}
+
+func newTags(m map[string]string) []*ec2.Tag {
+	var tags []*ec2.Tag
+	for k, v := range m {
+		tags = append(tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return tags
+}
+
+func setTag(tags []*ec2.Tag, key, value string) []*ec2.Tag {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key {
+			t.Value = aws.String(value)
+			return tags
+		}
+	}
+	return append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+func setState(i *ec2.Instance, state string) {
+	i.State = &ec2.InstanceState{
+		Name: aws.String(state),
+		Code: aws.Int64(stateCodes[state]),
+	}
+}
+
+func route(t *ec2.RouteTable, destinationCidrBlock string) *ec2.Route {
+	for _, r := range t.Routes {
+		if aws.StringValue(r.DestinationCidrBlock) == destinationCidrBlock {
+			return r
+		}
+	}
+	return nil
+}
+
+func instanceNotFound(id *string) error {
+	return awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%v' does not exist", aws.StringValue(id)), nil)
+}
+
+func contains(ids []*string, id string) bool {
+	for _, v := range ids {
+		if aws.StringValue(v) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFilters returns true if the resource matches every filter, attr returns the value of a resource attribute.
+// Unsupported filters return an error rather than silently matching.
+func matchFilters(filters []*ec2.Filter, tags []*ec2.Tag, attr func(name string) (string, bool)) (bool, error) {
+	for _, fl := range filters {
+		name := aws.StringValue(fl.Name)
+		var values []string
+		switch {
+		case strings.HasPrefix(name, "tag:"):
+			for _, t := range tags {
+				if aws.StringValue(t.Key) == strings.TrimPrefix(name, "tag:") {
+					values = append(values, aws.StringValue(t.Value))
+				}
+			}
+		case name == "tag-key":
+			for _, t := range tags {
+				values = append(values, aws.StringValue(t.Key))
+			}
+		default:
+			v, ok := attr(name)
+			if !ok {
+				return false, awserr.New("InvalidParameterValue", fmt.Sprintf("fake EC2 does not support filter %q", name), nil)
+			}
+			values = append(values, v)
+		}
+		if !matchAny(fl.Values, values) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func matchAny(want []*string, values []string) bool {
+	for _, w := range want {
+		for _, v := range values {
+			if aws.StringValue(w) == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pages splits n items into [start, end) pages of size, size 0 returns a single page
+func pages(n, size int) [][2]int {
+	if size <= 0 || size >= n {
+		return [][2]int{{0, n}}
+	}
+	var ps [][2]int
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		ps = append(ps, [2]int{start, end})
+	}
+	return ps
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+)
+
+// FencedRouter updates routes only for a leadership term at least as recent as the fence tag of the Routing Table.
+// The fence is raised to the term before the route is updated, so a leader of an older term which wakes up
+// after a newer leader updated the Routing Table aborts its plan.
+//
+// Tags have no conditional writes, so raising the fence is a read followed by a write: the fence is read back after
+// the write to abort if a newer term raised it meanwhile, but an older leader pausing between the read back and
+// the route update can still overwrite the route once. The next reconciliation of the newer leader corrects it.
+type FencedRouter struct {
+	Router
+	ec2  ec2iface.EC2API
+	key  string
+	term int64
+}
+
+// NewFencedRouter returns FencedRouter applying routes through r for term, with the fence in the tag key
+func NewFencedRouter(r Router, svc ec2iface.EC2API, key string, term int64) *FencedRouter {
+	return &FencedRouter{
+		Router: r,
+		ec2:    svc,
+		key:    key,
+		term:   term,
+	}
+}
+
+// UpsertNatRoute raises the fence of rt and updates its route, it returns an error caused by election.ErrStaleTerm
+// if rt is fenced by a newer term
+func (r *FencedRouter) UpsertNatRoute(ctx context.Context, destinationCidrBlock string, ni *discover.NatInstance, rt *discover.RoutingTable) error {
+	fence, err := r.fence(ctx, rt.Id)
+	if err != nil {
+		return err
+	}
+	if fence > r.term {
+		return errors.Wrapf(election.ErrStaleTerm, "RoutingTable %v is fenced by term %v, not updated in term %v", rt.Id, fence, r.term)
+	}
+	if fence < r.term {
+		log.Debugf("Raising fence of %v to term %v", rt.Id, r.term)
+		_, err := r.ec2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: []*string{aws.String(rt.Id)},
+			Tags: []*ec2.Tag{
+				{Key: aws.String(r.key), Value: aws.String(strconv.FormatInt(r.term, 10))},
+			},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Unable to raise fence of %v", rt.Id)
+		}
+		// a newer term may have raised the fence between the read and the write, and been overwritten
+		if fence, err = r.fence(ctx, rt.Id); err != nil {
+			return err
+		}
+		if fence > r.term {
+			return errors.Wrapf(election.ErrStaleTerm, "RoutingTable %v is fenced by term %v, not updated in term %v", rt.Id, fence, r.term)
+		}
+	}
+	return r.Router.UpsertNatRoute(ctx, destinationCidrBlock, ni, rt)
+}
+
+// fence returns the term in the fence tag of the Routing Table, 0 if unset
+func (r *FencedRouter) fence(ctx context.Context, id string) (int64, error) {
+	result, err := r.ec2.DescribeTagsWithContext(ctx, &ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: []*string{aws.String(id)},
+			},
+			{
+				Name:   aws.String("key"),
+				Values: []*string{aws.String(r.key)},
+			},
+		},
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "Unable to read fence of %v", id)
+	}
+	for _, t := range result.Tags {
+		fence, err := strconv.ParseInt(aws.StringValue(t.Value), 10, 64)
+		if err != nil {
+			return 0, errors.Errorf("Malformed %v=%v on %v", r.key, aws.StringValue(t.Value), id)
+		}
+		return fence, nil
+	}
+	return 0, nil
+}
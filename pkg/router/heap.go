@@ -0,0 +1,56 @@
+package router
+
+import "container/heap"
+
+// allocationHeap is a min-heap of NatInstanceAllocations ordered by the number of allocated routing tables,
+// ties are broken by the order in which the NatInstanceAllocations were pushed
+type allocationHeap struct {
+	items []*NatInstanceAllocation
+	order []int
+	next  int
+}
+
+func (h allocationHeap) Len() int { return len(h.items) }
+
+func (h allocationHeap) Less(i, j int) bool {
+	li, lj := len(h.items[i].RoutingTables), len(h.items[j].RoutingTables)
+	if li != lj {
+		return li < lj
+	}
+	return h.order[i] < h.order[j]
+}
+
+func (h allocationHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.order[i], h.order[j] = h.order[j], h.order[i]
+}
+
+// Push is used by container/heap, use push instead
+func (h *allocationHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*NatInstanceAllocation))
+	h.order = append(h.order, h.next)
+	h.next++
+}
+
+// Pop is used by container/heap
+func (h *allocationHeap) Pop() interface{} {
+	n := len(h.items) - 1
+	x := h.items[n]
+	h.items, h.order = h.items[:n], h.order[:n]
+	return x
+}
+
+// push adds a NatInstanceAllocation to the heap
+func (h *allocationHeap) push(nia *NatInstanceAllocation) {
+	heap.Push(h, nia)
+}
+
+// least returns the NatInstanceAllocation with least allocated routing tables
+func (h *allocationHeap) least() *NatInstanceAllocation {
+	return h.items[0]
+}
+
+// fixLeast restores the heap ordering after routing tables were allocated to least()
+func (h *allocationHeap) fixLeast() {
+	heap.Fix(h, 0)
+}
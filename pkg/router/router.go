@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"fmt"
 	"sort"
 
@@ -18,11 +19,14 @@ import (
 type Router interface {
 	// UpsertNatRoute replace or create a route through specified Instance Id
 	// return nil if successful or the AWS error
-	UpsertNatRoute(destinationCidrBlock string, ni *discover.NatInstance, rt *discover.RoutingTable) error
+	UpsertNatRoute(ctx context.Context, destinationCidrBlock string, ni *discover.NatInstance, rt *discover.RoutingTable) error
 	// PreventSourceDestCheck ensures source/destination checking is disabled as required for a NAT instance to perform NAT
-	PreventSourceDestCheck(ni *discover.NatInstance) error
+	PreventSourceDestCheck(ctx context.Context, ni *discover.NatInstance) error
 }
 
+// FileFinder applies routes to its inventory
+var _ Router = (*discover.FileFinder)(nil)
+
 // NatInstanceAllocation holds a list of all the routingTables allocated to a specific NatInstance
 type NatInstanceAllocation struct {
 	NatInstance   *discover.NatInstance
@@ -36,39 +40,53 @@ func AllocateRoutes(nis []*discover.NatInstance, rts []*discover.RoutingTable) [
 		return nil
 	}
 
-	var all []*NatInstanceAllocation
-	// index routes by zone
-	zoned := make(map[string][]*NatInstanceAllocation)
+	all := make([]*NatInstanceAllocation, 0, len(nis))
+	// index routes by zone, using AZ IDs where known to be consistent across accounts
+	zoned := make(map[string]*allocationHeap)
 	for _, ni := range nis {
 		r := &NatInstanceAllocation{
 			NatInstance: ni,
 		}
 		all = append(all, r)
-		zoned[ni.Zone] = append(zoned[ni.Zone], r)
+		z, ok := zoned[ni.ZoneKey()]
+		if !ok {
+			z = &allocationHeap{}
+			zoned[ni.ZoneKey()] = z
+		}
+		z.push(r)
 	}
 
+	// allocate rt to NatInstance in same zone first
+	var unzoned []*discover.RoutingTable
 	for _, rt := range rts {
-		if zni, ok := zoned[rt.Zone]; ok {
-			allocateRouteToLeast(rt, zni) // allocate rt to NatInstance in same zone
+		if z, ok := zoned[rt.ZoneKey()]; ok {
+			allocateRouteToLeast(rt, z)
 		} else {
-			allocateRouteToLeast(rt, all) // no NatInstance in zone, allocate rt to any zone
+			unzoned = append(unzoned, rt)
+		}
+	}
+
+	// no NatInstance in zone, allocate rt to any zone
+	if len(unzoned) > 0 {
+		global := &allocationHeap{}
+		for _, r := range all {
+			global.push(r)
+		}
+		for _, rt := range unzoned {
+			allocateRouteToLeast(rt, global)
 		}
 	}
 	return all
 }
 
-// allocateRouteToLeast will find the NatInstance with least allocated routing tables to allocate to
-func allocateRouteToLeast(rt *discover.RoutingTable, nrs []*NatInstanceAllocation) {
+// allocateRouteToLeast will allocate to the NatInstance with least allocated routing tables
+func allocateRouteToLeast(rt *discover.RoutingTable, h *allocationHeap) {
 	// assumes at least 1 NatInstance exists
-	// find NatRoute with least routing tables
-	c := nrs[0]
-	for _, i := range nrs {
-		if len(i.RoutingTables) < len(c.RoutingTables) {
-			c = i
-		}
-	}
+	// the NatInstance with least routing tables is at the root of the heap
+	c := h.least()
 	// append routing table for this NatInstance
 	c.RoutingTables = append(c.RoutingTables, rt)
+	h.fixLeast()
 }
 
 // AwsRouter implements Router interface for AWS
@@ -89,16 +107,19 @@ func NewAwsRouter(svc ec2iface.EC2API) (Router, error) {
 }
 
 // UpsertNatRoute replace or create a route through specified Instance Id
-func (r *AwsRouter) UpsertNatRoute(destinationCidrBlock string, ni *discover.NatInstance, rt *discover.RoutingTable) error {
+func (r *AwsRouter) UpsertNatRoute(ctx context.Context, destinationCidrBlock string, ni *discover.NatInstance, rt *discover.RoutingTable) error {
 	input := &ec2.ReplaceRouteInput{
 		DestinationCidrBlock: aws.String(destinationCidrBlock),
 		InstanceId:           aws.String(ni.Id),
 		RouteTableId:         aws.String(rt.Id),
 	}
 
-	log.Debugf("Routing %v (%v) via %v (%v)", rt.Id, rt.Zone, ni.Id, ni.Zone)
-	_, err := r.ec2.ReplaceRoute(input)
+	log.Debugf("Routing %v (%v) via %v (%v)", rt.Id, rt.ZoneKey(), ni.Id, ni.ZoneKey())
+	_, err := r.ec2.ReplaceRouteWithContext(ctx, input)
 	if err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(err, "Unable to update route")
+		}
 		// if replace route failed, maybe the route didn't exist
 		input := &ec2.CreateRouteInput{
 			DestinationCidrBlock: aws.String(destinationCidrBlock),
@@ -106,7 +127,7 @@ func (r *AwsRouter) UpsertNatRoute(destinationCidrBlock string, ni *discover.Nat
 			RouteTableId:         aws.String(rt.Id),
 		}
 
-		_, err := r.ec2.CreateRoute(input)
+		_, err := r.ec2.CreateRouteWithContext(ctx, input)
 		if err != nil {
 			return errors.Wrap(err, "Unable to update route")
 			// if aerr, ok := err.(awserr.Error); ok {
@@ -128,7 +149,7 @@ func (r *AwsRouter) UpsertNatRoute(destinationCidrBlock string, ni *discover.Nat
 }
 
 // PreventSourceDestCheck ensures source/destination checking is disabled as required for a NAT instance to perform NAT
-func (r *AwsRouter) PreventSourceDestCheck(ni *discover.NatInstance) error {
+func (r *AwsRouter) PreventSourceDestCheck(ctx context.Context, ni *discover.NatInstance) error {
 	// https://docs.aws.amazon.com/sdk-for-go/api/service/ec2/#EC2.ModifyInstanceAttribute
 	// Note: Using this action to change the security groups associated with an elastic network interface (ENI)
 	// attached to an instance in a VPC can result in an error if the instance has more than one ENI.
@@ -144,7 +165,7 @@ func (r *AwsRouter) PreventSourceDestCheck(ni *discover.NatInstance) error {
 			},
 		}
 
-		_, err := r.ec2.ModifyInstanceAttribute(input)
+		_, err := r.ec2.ModifyInstanceAttributeWithContext(ctx, input)
 
 		// https://docs.aws.amazon.com/sdk-for-go/api/service/ec2/#EC2.ModifyNetworkInterfaceAttribute
 		// input := &ec2.ModifyNetworkInterfaceAttributeInput{
@@ -161,30 +182,25 @@ func (r *AwsRouter) PreventSourceDestCheck(ni *discover.NatInstance) error {
 	return nil
 }
 
-// findNis looks up the NatInstance for a given instanceId if it exists
-func findNis(nis []*discover.NatInstance, instanceId string) *discover.NatInstance {
-	for _, ni := range nis {
-		if ni.Id == instanceId {
-			return ni
-		}
-	}
-	return nil
-}
-
 // GetCurrentAllocation uses the discovered information to build NatInstanceAllocation in memory
 func GetCurrentAllocation(nis []*discover.NatInstance, rts []*discover.RoutingTable) []*NatInstanceAllocation {
 	if len(nis) < 1 || len(rts) < 1 {
 		return nil
 	}
 
+	nisById := make(map[string]*discover.NatInstance, len(nis))
+	for _, ni := range nis {
+		nisById[ni.Id] = ni
+	}
+
 	byInstanceId := make(map[string]*NatInstanceAllocation)
 	for _, rt := range rts {
 		log.Debugf("rt: %q - egress: %q", rt.Id, rt.EgressNatInstanceId)
 		if nia, ok := byInstanceId[rt.EgressNatInstanceId]; ok {
 			nia.RoutingTables = append(nia.RoutingTables, rt)
 		} else {
-			ni := findNis(nis, rt.EgressNatInstanceId)
-			if ni != nil {
+			ni, ok := nisById[rt.EgressNatInstanceId]
+			if ok {
 				nia := &NatInstanceAllocation{
 					NatInstance: ni,
 				}
@@ -244,7 +260,7 @@ func AllocationDiffers(old, new []*NatInstanceAllocation) bool {
 
 		for j := range old[i].RoutingTables {
 			if old[i].RoutingTables[j].Id != new[i].RoutingTables[j].Id {
-				log.Debugf("Route for instance: %v at %v is for a different routing table: %v (old) vs %v (new)", j, old[i].RoutingTables[j].Id, new[i].RoutingTables[j].Id)
+				log.Debugf("Route for instance: %v at %v is for a different routing table: %v (old) vs %v (new)", old[i].NatInstance.Id, j, old[i].RoutingTables[j].Id, new[i].RoutingTables[j].Id)
 				return true
 			}
 		}
@@ -259,12 +275,12 @@ func (a NatInstanceAllocation) String() string {
 		a.NatInstance.PrivateIP,
 		a.NatInstance.PublicIP,
 		a.NatInstance.SourceDestCheck,
-		a.NatInstance.Zone,
+		a.NatInstance.ZoneKey(),
 	)
 	for _, r := range a.RoutingTables {
 		s += fmt.Sprintf("\t Route: %v Zone: %v (Egress: %v)\n",
 			r.Id,
-			r.Zone,
+			r.ZoneKey(),
 			r.EgressNatInstanceId,
 		)
 	}
@@ -1,25 +1,247 @@
 package router_test
 
-// // add some sample scenarios
-// nis = append(nis, discover.NatInstance{
-// 	Id:   "i-apse1a-1",
-// 	Zone: "ap-southeast-1a",
-// })
-
-// rts = append(rts, discover.RoutingTable{
-// 	Id:   "rtb-apse1a-2",
-// 	Zone: "ap-southeast-1a",
-// })
-// rts = append(rts, discover.RoutingTable{
-// 	Id:   "rtb-apse1a-3",
-// 	Zone: "ap-southeast-1a",
-// })
-// rts = append(rts, discover.RoutingTable{
-// 	Id:   "rtb-apse1a-4",
-// 	Zone: "ap-southeast-1a",
-// })
-
-// rts = append(rts, discover.RoutingTable{
-// 	Id:   "rtb-bar",
-// 	Zone: "ap-southeast-1d",
-// })
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+	"github.com/so0k/aws-nat-router/pkg/fake"
+	"github.com/so0k/aws-nat-router/pkg/router"
+)
+
+func TestAllocateRoutes(t *testing.T) {
+	nis := []*discover.NatInstance{
+		{Id: "i-apse1a-1", Zone: "ap-southeast-1a"},
+		{Id: "i-apse1b-1", Zone: "ap-southeast-1b"},
+	}
+	rts := []*discover.RoutingTable{
+		{Id: "rtb-apse1a-2", Zone: "ap-southeast-1a"},
+		{Id: "rtb-apse1a-3", Zone: "ap-southeast-1a"},
+		{Id: "rtb-apse1a-4", Zone: "ap-southeast-1a"},
+		{Id: "rtb-bar", Zone: "ap-southeast-1d"},
+	}
+
+	nias := router.AllocateRoutes(nis, rts)
+	got := make(map[string]string)
+	for _, nia := range nias {
+		for _, rt := range nia.RoutingTables {
+			got[rt.Id] = nia.NatInstance.Id
+		}
+	}
+	want := map[string]string{
+		"rtb-apse1a-2": "i-apse1a-1",
+		"rtb-apse1a-3": "i-apse1a-1",
+		"rtb-apse1a-4": "i-apse1a-1",
+		"rtb-bar":      "i-apse1b-1", // no NatInstance in zone, allocated to least
+	}
+	for rt, ni := range want {
+		if got[rt] != ni {
+			t.Errorf("%v allocated to %q, want %q", rt, got[rt], ni)
+		}
+	}
+}
+
+func TestAllocateRoutesBalancesZone(t *testing.T) {
+	nis, rts := scenario(3, 2, 600)
+	for _, nia := range router.AllocateRoutes(nis, rts) {
+		if len(nia.RoutingTables) != 100 {
+			t.Errorf("%v allocated %v routing tables, want 100", nia.NatInstance.Id, len(nia.RoutingTables))
+		}
+		for _, rt := range nia.RoutingTables {
+			if rt.Zone != nia.NatInstance.Zone {
+				t.Errorf("%v (%v) allocated to %v (%v)", rt.Id, rt.Zone, nia.NatInstance.Id, nia.NatInstance.Zone)
+			}
+		}
+	}
+}
+
+func TestGetCurrentAllocation(t *testing.T) {
+	nis := []*discover.NatInstance{
+		{Id: "i-a"},
+		{Id: "i-b"},
+	}
+	rts := []*discover.RoutingTable{
+		{Id: "rtb-1", EgressNatInstanceId: "i-a"},
+		{Id: "rtb-2", EgressNatInstanceId: "i-a"},
+		{Id: "rtb-3", EgressNatInstanceId: "i-dead"}, // not a live instance
+		{Id: "rtb-4"},
+	}
+	nias := router.GetCurrentAllocation(nis, rts)
+	if len(nias) != 1 || nias[0].NatInstance.Id != "i-a" || len(nias[0].RoutingTables) != 2 {
+		t.Errorf("got %v, want rtb-1 and rtb-2 allocated to i-a", nias)
+	}
+}
+
+func TestAllocationDiffers(t *testing.T) {
+	nis, rts := scenario(2, 1, 4)
+	// scenario routes every routing table through an instance in its zone
+	if router.AllocationDiffers(router.GetCurrentAllocation(nis, rts), router.AllocateRoutes(nis, rts)) {
+		t.Error("allocation differs for routes already allocated")
+	}
+	rts[0].EgressNatInstanceId = nis[1].Id
+	if !router.AllocationDiffers(router.GetCurrentAllocation(nis, rts), router.AllocateRoutes(nis, rts)) {
+		t.Error("allocation does not differ for route through another zone")
+	}
+}
+
+func TestAwsRouter(t *testing.T) {
+	f := fake.NewEC2()
+	f.AddInstance(fake.Instance{Id: "i-a"})
+	f.AddInstance(fake.Instance{Id: "i-b"})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-new"})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-old", Egress: "i-a"})
+	r, _ := router.NewAwsRouter(f)
+
+	ni := &discover.NatInstance{Id: "i-b", SourceDestCheck: true}
+	for _, id := range []string{"rtb-new", "rtb-old"} {
+		if err := r.UpsertNatRoute(context.Background(), "0.0.0.0/0", ni, &discover.RoutingTable{Id: id}); err != nil {
+			t.Errorf("UpsertNatRoute %v: %v", id, err)
+		}
+		if got := f.Egress(id); got != "i-b" {
+			t.Errorf("%v routes through %q, want i-b", id, got)
+		}
+	}
+	if err := r.PreventSourceDestCheck(context.Background(), ni); err != nil {
+		t.Errorf("PreventSourceDestCheck: %v", err)
+	}
+	if f.SourceDestCheck("i-b") {
+		t.Error("SourceDestCheck still enabled")
+	}
+
+	// a hung call is abandoned once the context is done
+	f.Hang("ReplaceRoute")
+	created := f.Calls("CreateRoute")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.UpsertNatRoute(ctx, "0.0.0.0/0", &discover.NatInstance{Id: "i-a"}, &discover.RoutingTable{Id: "rtb-old"}); err == nil {
+		t.Error("UpsertNatRoute of hung call succeeded, want error")
+	}
+	if n := f.Calls("CreateRoute") - created; n != 0 {
+		t.Errorf("CreateRoute called %v times after cancelled ReplaceRoute, want 0", n)
+	}
+	f.Recover("ReplaceRoute")
+
+	f.Fail("ReplaceRoute", -1, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil))
+	if err := r.UpsertNatRoute(context.Background(), "0.0.0.0/0", &discover.NatInstance{Id: "i-a"}, &discover.RoutingTable{Id: "rtb-old"}); err == nil {
+		t.Error("UpsertNatRoute succeeded, want error")
+	}
+}
+
+func TestFencedRouter(t *testing.T) {
+	f := fake.NewEC2()
+	f.AddInstance(fake.Instance{Id: "i-a"})
+	f.AddInstance(fake.Instance{Id: "i-b"})
+	f.AddRouteTable(fake.RouteTable{Id: "rtb-1", Egress: "i-a"})
+	r, _ := router.NewAwsRouter(f)
+	rt := &discover.RoutingTable{Id: "rtb-1"}
+	key := discover.DefaultTags.Fence
+
+	// the leader of term 2 raises the fence
+	if err := router.NewFencedRouter(r, f, key, 2).UpsertNatRoute(context.Background(), "0.0.0.0/0", &discover.NatInstance{Id: "i-b"}, rt); err != nil {
+		t.Fatalf("UpsertNatRoute: %v", err)
+	}
+	if got := f.Tag("rtb-1", key); got != "2" {
+		t.Errorf("fence %q, want 2", got)
+	}
+
+	// a former leader of term 1 is refused
+	err := router.NewFencedRouter(r, f, key, 1).UpsertNatRoute(context.Background(), "0.0.0.0/0", &discover.NatInstance{Id: "i-a"}, rt)
+	if errors.Cause(err) != election.ErrStaleTerm {
+		t.Errorf("UpsertNatRoute = %v, want %v", err, election.ErrStaleTerm)
+	}
+	if got := f.Egress("rtb-1"); got != "i-b" {
+		t.Errorf("rtb-1 routes through %q, want i-b", got)
+	}
+
+	// a malformed fence is not overwritten
+	f.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String("rtb-1")},
+		Tags:      []*ec2.Tag{{Key: aws.String(key), Value: aws.String("two")}},
+	})
+	if err := router.NewFencedRouter(r, f, key, 3).UpsertNatRoute(context.Background(), "0.0.0.0/0", &discover.NatInstance{Id: "i-a"}, rt); err == nil {
+		t.Error("UpsertNatRoute with malformed fence succeeded, want error")
+	}
+	if got := f.Tag("rtb-1", key); got != "two" {
+		t.Errorf("fence %q, want two", got)
+	}
+}
+
+// scenario returns NatInstances spread over zones and RoutingTables spread over the same zones
+func scenario(zones, perZone, routingTables int) ([]*discover.NatInstance, []*discover.RoutingTable) {
+	var nis []*discover.NatInstance
+	launch := time.Now()
+	for z := 0; z < zones; z++ {
+		for i := 0; i < perZone; i++ {
+			nis = append(nis, &discover.NatInstance{
+				Id:         fmt.Sprintf("i-%v-%v", z, i),
+				Zone:       fmt.Sprintf("zone-%v", z),
+				LaunchTime: launch.Add(time.Duration(len(nis)) * time.Second),
+			})
+		}
+	}
+	rts := make([]*discover.RoutingTable, routingTables)
+	for i := range rts {
+		rts[i] = &discover.RoutingTable{
+			Id:                  fmt.Sprintf("rtb-%v", i),
+			Zone:                fmt.Sprintf("zone-%v", i%zones),
+			EgressNatInstanceId: nis[i%len(nis)].Id,
+		}
+	}
+	return nis, rts
+}
+
+func benchmarkAllocateRoutes(b *testing.B, routingTables int) {
+	nis, rts := scenario(3, 2, routingTables)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		router.AllocateRoutes(nis, rts)
+	}
+}
+
+func BenchmarkAllocateRoutes10(b *testing.B)    { benchmarkAllocateRoutes(b, 10) }
+func BenchmarkAllocateRoutes100(b *testing.B)   { benchmarkAllocateRoutes(b, 100) }
+func BenchmarkAllocateRoutes1000(b *testing.B)  { benchmarkAllocateRoutes(b, 1000) }
+func BenchmarkAllocateRoutes10000(b *testing.B) { benchmarkAllocateRoutes(b, 10000) }
+
+func benchmarkAllocateRoutesManyInstances(b *testing.B, perZone int) {
+	nis, rts := scenario(3, perZone, 10000)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		router.AllocateRoutes(nis, rts)
+	}
+}
+
+func BenchmarkAllocateRoutesInstances6(b *testing.B)   { benchmarkAllocateRoutesManyInstances(b, 2) }
+func BenchmarkAllocateRoutesInstances60(b *testing.B)  { benchmarkAllocateRoutesManyInstances(b, 20) }
+func BenchmarkAllocateRoutesInstances600(b *testing.B) { benchmarkAllocateRoutesManyInstances(b, 200) }
+
+func benchmarkGetCurrentAllocation(b *testing.B, routingTables int) {
+	nis, rts := scenario(3, 2, routingTables)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		router.GetCurrentAllocation(nis, rts)
+	}
+}
+
+func BenchmarkGetCurrentAllocation100(b *testing.B)   { benchmarkGetCurrentAllocation(b, 100) }
+func BenchmarkGetCurrentAllocation10000(b *testing.B) { benchmarkGetCurrentAllocation(b, 10000) }
+
+func benchmarkAllocationDiffers(b *testing.B, routingTables int) {
+	nis, rts := scenario(3, 2, routingTables)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		old := router.GetCurrentAllocation(nis, rts)
+		new := router.AllocateRoutes(nis, rts)
+		router.AllocationDiffers(old, new)
+	}
+}
+
+func BenchmarkAllocationDiffers100(b *testing.B)   { benchmarkAllocationDiffers(b, 100) }
+func BenchmarkAllocationDiffers10000(b *testing.B) { benchmarkAllocationDiffers(b, 10000) }
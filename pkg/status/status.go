@@ -0,0 +1,153 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/so0k/aws-nat-router/pkg/discover"
+	"github.com/so0k/aws-nat-router/pkg/election"
+	"github.com/so0k/aws-nat-router/pkg/router"
+)
+
+// Cycle describes the outcome of a single reconciliation
+type Cycle struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Role     string    `json:"role"`
+	// Leader is the node this node considers leader, if known
+	Leader string `json:"leader,omitempty"`
+	// Term is the leadership term of an active node where leases are used
+	Term int64 `json:"term,omitempty"`
+	// Takeover is set when this node took over from a leader which stopped reconciling
+	Takeover *election.Takeover `json:"takeover,omitempty"`
+	// Degraded is set when a Routing Table routed through a dead NAT Instance or was blackholed
+	Degraded bool `json:"degraded,omitempty"`
+	// Throttled is set when AWS throttled an API call
+	Throttled   bool             `json:"throttled,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	Healthy     []string         `json:"healthy"`
+	Unhealthy   []string         `json:"unhealthy"`
+	Draining    []string         `json:"draining,omitempty"`
+	Allocations []Allocation     `json:"allocations,omitempty"`
+	Report      *discover.Report `json:"report,omitempty"`
+}
+
+// Allocation describes the routing tables allocated to a NAT Instance
+type Allocation struct {
+	InstanceId    string   `json:"instanceId"`
+	Zone          string   `json:"zone"`
+	RoutingTables []string `json:"routingTables"`
+}
+
+// NewAllocations returns the Allocations for NatInstanceAllocations
+func NewAllocations(nias []*router.NatInstanceAllocation) []Allocation {
+	as := make([]Allocation, 0, len(nias))
+	for _, nia := range nias {
+		a := Allocation{
+			InstanceId: nia.NatInstance.Id,
+			Zone:       nia.NatInstance.ZoneKey(),
+		}
+		for _, rt := range nia.RoutingTables {
+			a.RoutingTables = append(a.RoutingTables, rt.Id)
+		}
+		as = append(as, a)
+	}
+	return as
+}
+
+// Status holds the last reconciliation Cycle of each target and serves them over HTTP
+type Status struct {
+	mu   sync.RWMutex
+	last map[string]*Cycle
+}
+
+// New returns an empty Status
+func New() *Status {
+	return &Status{
+		last: make(map[string]*Cycle),
+	}
+}
+
+// Update records the last reconciliation Cycle of target
+func (s *Status) Update(target string, c *Cycle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[target] = c
+}
+
+// Last returns the last reconciliation Cycle of target or nil if none finished yet
+func (s *Status) Last(target string) *Cycle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last[target]
+}
+
+// Targets returns the last reconciliation Cycle of every target
+func (s *Status) Targets() map[string]*Cycle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	targets := make(map[string]*Cycle, len(s.last))
+	for t, c := range s.last {
+		targets[t] = c
+	}
+	return targets
+}
+
+// ServeHTTP writes the last reconciliation Cycle of every target as JSON,
+// or of a single target if the target query parameter is set
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var v interface{}
+	if target := r.URL.Query().Get("target"); target != "" {
+		c := s.Last(target)
+		if c == nil {
+			http.Error(w, "no reconciliation finished yet for target "+target, http.StatusServiceUnavailable)
+			return
+		}
+		v = c
+	} else {
+		v = map[string]interface{}{"targets": s.Targets()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("Unable to write status: %v", err)
+	}
+}
+
+// ListenAndServe serves the Status on addr at /status
+func ListenAndServe(addr string, s *Status) error {
+	mux := http.NewServeMux()
+	mux.Handle("/status", s)
+	log.Infof("Serving status on %v/status", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Get returns the last reconciliation Cycle of target from the status served by a peer on addr,
+// the request is cancelled with ctx or after timeout
+func Get(ctx context.Context, addr, target string, timeout time.Duration) (*Cycle, error) {
+	u := fmt.Sprintf("http://%v/status?target=%v", addr, url.QueryEscape(target))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to get status from %v", addr)
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to get status from %v", addr)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unable to get status of %v from %v: %v", target, addr, resp.Status)
+	}
+	c := &Cycle{}
+	if err := json.NewDecoder(resp.Body).Decode(c); err != nil {
+		return nil, errors.Wrapf(err, "Unable to decode status from %v", addr)
+	}
+	return c, nil
+}